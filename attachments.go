@@ -0,0 +1,262 @@
+package main
+
+import (
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Blobs are stored as <cipher id>/<attachment id>
+func attachmentKey(ciphID string, attID string) string {
+	return ciphID + "/" + attID
+}
+
+func attachmentURL(ciphID string, attID string) string {
+	return baseURL + "/api/ciphers/" + ciphID + "/attachment/" + attID
+}
+
+// setAttachmentURLs fills in where the clients can download the attachments
+func setAttachmentURLs(ciphs []Cipher) {
+	for i := range ciphs {
+		for j := range ciphs[i].Attachments {
+			a := &ciphs[i].Attachments[j]
+
+			u, err := blobs.url(attachmentKey(ciphs[i].Id, a.Id))
+			if err != nil {
+				log.Println(err)
+			}
+			if u == "" {
+				u = attachmentURL(ciphs[i].Id, a.Id)
+			}
+			a.Url = u
+		}
+	}
+}
+
+// formFileName returns the file name of an uploaded file as the client sent
+// it. header.Filename only keeps what follows the last slash, but the name is
+// an encrypted string and base64 can contain slashes.
+func formFileName(header *multipart.FileHeader) string {
+	_, params, err := mime.ParseMediaType(header.Header.Get("Content-Disposition"))
+	if err != nil {
+		return header.Filename
+	}
+	return params["filename"]
+}
+
+func removeAttachmentBlobs(attachments []Attachment) {
+	for _, a := range attachments {
+		err := blobs.delete(attachmentKey(a.CipherId, a.Id))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// moveAttachmentBlobs moves the blobs of the ciphers that got new ids when
+// the database was migrated. A cipher is only marked as done once all of its
+// blobs are moved, the others are tried again on the next start.
+func moveAttachmentBlobs() {
+	ciphs, err := db.getUnmovedCiphers()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, ciph := range ciphs {
+		moved := true
+		for _, a := range ciph.Attachments {
+			err := moveBlob(attachmentKey(ciph.LegacyId, a.Id), attachmentKey(ciph.Id, a.Id), a.Size)
+			if err != nil {
+				log.Println(err)
+				moved = false
+			}
+		}
+		if !moved {
+			continue
+		}
+
+		err = db.setBlobsMoved(ciph.Id)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// moveBlob is safe to repeat if it was interrupted, also after the old blob
+// was removed
+func moveBlob(from string, to string, size string) error {
+	isize, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	r, err := blobs.get(from)
+	if err != nil {
+		moved, err2 := blobs.get(to)
+		if err2 != nil {
+			return err
+		}
+		moved.Close()
+		return nil
+	}
+	defer r.Close()
+
+	err = blobs.put(to, r, isize)
+	if err != nil {
+		return err
+	}
+
+	return blobs.delete(from)
+}
+
+// This function handles /api/ciphers/{id}/attachment and /api/ciphers/{id}/attachment/{attachmentId}
+func handleAttachment(w http.ResponseWriter, req *http.Request, acc Account, ciphID string, attID string) {
+	switch {
+	case req.Method == "POST" && attID == "":
+		handleNewAttachment(w, req, acc, ciphID)
+		return
+
+	case req.Method == "GET" && attID != "":
+		att, err := db.getAttachment(acc.Id, ciphID, attID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+
+		f, err := blobs.get(attachmentKey(att.CipherId, att.Id))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", att.Size)
+		io.Copy(w, f)
+		return
+
+	case req.Method == "DELETE" && attID != "":
+		att, err := db.getAttachment(acc.Id, ciphID, attID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+
+		err = db.deleteAttachment(acc.Id, ciphID, attID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+
+		removeAttachmentBlobs([]Attachment{att})
+		pushCipher(req, pushSyncCipherUpdate, acc.Id, att.CipherId)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(""))
+		log.Println("Attachment " + attID + " deleted")
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+}
+
+func handleNewAttachment(w http.ResponseWriter, req *http.Request, acc Account, ciphID string) {
+	log.Println(acc.Email + " is trying to add an attachment")
+
+	// The blob is stored under the cipher's UUID, also if the client used an old id
+	ciph, err := db.getCipher(acc.Id, ciphID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(404)))
+		log.Println(err)
+		return
+	}
+	ciphID = ciph.Id
+
+	// Leave some room for the form fields around the file
+	req.Body = http.MaxBytesReader(w, req.Body, maxAttachmentSize+1<<20)
+
+	err = req.ParseMultipartForm(32 << 20)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println(err)
+		return
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	file, header, err := req.FormFile("data")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println(err)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxAttachmentSize {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println("Attachment too large")
+		return
+	}
+
+	err = validateEncFields([]encField{{"FileName", formFileName(header), true}, {"Key", req.FormValue("key"), true}})
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	att := Attachment{
+		Id:       uuid.NewV4().String(),
+		CipherId: ciphID,
+		FileName: formFileName(header),
+		Key:      req.FormValue("key"),
+		Size:     strconv.FormatInt(header.Size, 10),
+		Object:   "attachment",
+	}
+
+	// Checks that the cipher belongs to the account
+	err = db.addAttachment(att, acc.Id)
+	if err == errQuotaExceeded {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(404)))
+		log.Println(err)
+		return
+	}
+
+	err = blobs.put(attachmentKey(ciphID, att.Id), file, header.Size)
+	if err != nil {
+		db.deleteAttachment(acc.Id, ciphID, att.Id)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(500)))
+		log.Println(err)
+		return
+	}
+
+	pushCipher(req, pushSyncCipherUpdate, acc.Id, ciphID)
+
+	log.Println("Attachment " + att.Id + " added to cipher " + ciphID)
+	writeCipher(w, acc, ciphID)
+}
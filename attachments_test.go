@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+)
+
+// The second name has slashes in its base64 parts
+var attachmentNames = []string{
+	"2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=",
+	"2.d7MttWzJTSSK/1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4/qIhcLqhiZHn+NsUDp8dAg1Xu0Io=",
+}
+
+// attachmentForm returns a multipart form like the one the clients upload
+func attachmentForm(t *testing.T, name string) (*bytes.Buffer, *multipart.Writer) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	err := mw.WriteField("key", attachmentNames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fw, err := mw.CreateFormFile("data", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("2.encrypted attachment data"))
+
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw
+}
+
+func TestFormFileName(t *testing.T) {
+	for _, name := range attachmentNames {
+		body, mw := attachmentForm(t, name)
+		form, err := multipart.NewReader(body, mw.Boundary()).ReadForm(1 << 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := formFileName(form.File["data"][0])
+		if got != name {
+			t.Errorf("Expected %v got %v", name, got)
+		}
+		form.RemoveAll()
+	}
+}
+
+func TestHandleNewAttachment(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+	_, cleanupBlobs := useTestBlobStore(t)
+	defer cleanupBlobs()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	ciph, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		expected int
+	}{{attachmentNames[0], 200},
+		{attachmentNames[1], 200},
+		{"not encrypted", 400}}
+
+	for _, c := range cases {
+		body, mw := attachmentForm(t, c.name)
+		req := testRequest(t, acc, "POST", "/api/ciphers/"+ciph.Id+"/attachment", "")
+		req.Body = ioutil.NopCloser(body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		res := httptest.NewRecorder()
+		handleNewAttachment(res, req, acc, ciph.Id)
+		if res.Code != c.expected {
+			t.Errorf("Expected %v got %v", c.expected, res.Code)
+		}
+	}
+
+	attachments, err := d.getAttachments(acc.Id, ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[string]bool)
+	for _, a := range attachments {
+		stored[a.FileName] = true
+	}
+	if len(attachments) != 2 || !stored[attachmentNames[0]] || !stored[attachmentNames[1]] {
+		t.Errorf("Expected both encrypted names to be stored got %+v", attachments)
+	}
+}
+
+func TestMoveAttachmentBlobs(t *testing.T) {
+	d, cleanup := openBaselineDB(t,
+		"CREATE TABLE \"attachments\" ( `id` TEXT, `cipher` INTEGER, `filename` TEXT, `key` TEXT, `size` INTEGER, PRIMARY KEY(id) );",
+		"INSERT INTO attachments(id, cipher, filename, key, size) values('a', 1, 'name', 'key', 4)",
+		"INSERT INTO attachments(id, cipher, filename, key, size) values('b', 1, 'name', 'key', 4)",
+	)
+	defer cleanup()
+	old := db
+	db = d
+	defer func() { db = old }()
+	store, cleanupBlobs := useTestBlobStore(t)
+	defer cleanupBlobs()
+
+	acc, err := d.getAccount("nobody@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciph, err := d.getCipher(acc.Id, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(key string) {
+		err := store.put(key, bytes.NewReader([]byte("data")), 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(key string) bool {
+		r, err := store.get(key)
+		if err != nil {
+			return false
+		}
+		r.Close()
+		return true
+	}
+
+	// The cipher is tried again while one of its blobs can't be moved
+	put(attachmentKey("1", "a"))
+	moveAttachmentBlobs()
+	if exists(attachmentKey("1", "a")) || !exists(attachmentKey(ciph.Id, "a")) {
+		t.Error("Expected blob a to be moved")
+	}
+	ciphs, err := d.getUnmovedCiphers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 1 || ciphs[0].Id != ciph.Id || ciphs[0].LegacyId != "1" || len(ciphs[0].Attachments) != 2 {
+		t.Fatalf("Expected cipher %v to be left got %+v", ciph.Id, ciphs)
+	}
+
+	put(attachmentKey("1", "b"))
+	moveAttachmentBlobs()
+	if exists(attachmentKey("1", "b")) || !exists(attachmentKey(ciph.Id, "a")) || !exists(attachmentKey(ciph.Id, "b")) {
+		t.Error("Expected both blobs under the new id")
+	}
+	ciphs, err = d.getUnmovedCiphers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 0 {
+		t.Errorf("Expected all blobs to be moved got %+v", ciphs)
+	}
+}
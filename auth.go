@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	b64 "encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -25,6 +26,12 @@ func handleRegister(w http.ResponseWriter, req *http.Request) {
 
 	log.Println(acc.Email + " is trying to register")
 
+	err = validateEncFields([]encField{{"Key", acc.Key, true}})
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
 	err = db.addAccount(acc)
 	if err != nil {
 		log.Fatal(err)
@@ -66,15 +73,19 @@ func handleLogin(w http.ResponseWriter, req *http.Request) {
 	var acc Account
 	var err error
 	if grantType[0] == "refresh_token" {
-		rrefreshToken := req.PostForm["refresh_token"][0]
+		rrefreshToken := req.PostForm.Get("refresh_token")
 		if len(rrefreshToken) < 4 {
-			// Fatal to always catch this
-			log.Fatal("fake refreshToken " + rrefreshToken)
+			writeGrantError(w)
+			log.Println("fake refreshToken " + rrefreshToken)
+			return
 		}
 
+		// Also when the token was revoked by a key rotation
 		acc, err = db.getAccount("", rrefreshToken)
 		if err != nil {
-			log.Fatal(err)
+			writeGrantError(w)
+			log.Println(err)
+			return
 		}
 		log.Println(acc.Email + " is trying to refresh a token " + rrefreshToken)
 		if acc.RefreshToken != rrefreshToken {
@@ -112,10 +123,12 @@ func handleLogin(w http.ResponseWriter, req *http.Request) {
 	claims["nbf"] = time.Now().Unix()
 	claims["exp"] = time.Now().Add(time.Second * time.Duration(jwtExpire)).Unix()
 	claims["iss"] = "NA"
-	claims["sub"] = "NA"
+	claims["sub"] = acc.Id
 	claims["email"] = acc.Email
 	claims["name"] = acc.Name
 	claims["premium"] = false
+	claims["sstamp"] = acc.SecurityStamp
+	claims["device"] = req.PostForm.Get("deviceIdentifier") // Sent back in notifications so the device can ignore its own changes
 	tokenString, _ := token.SignedString(mySigningKey)
 
 	rtoken := resToken{AccessToken: tokenString,
@@ -134,45 +147,83 @@ func handleLogin(w http.ResponseWriter, req *http.Request) {
 	w.Write(data)
 }
 
+// writeGrantError tells the client that its refresh token isn't valid, so
+// it logs out instead of retrying
+func writeGrantError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(`{"error":"invalid_grant"}`))
+}
+
 type ctxKey string
 
-func jwtMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		var tokenString string
+// The tokens of an account are revoked when its security stamp changes
+func validSecurityStamp(email string, stamp interface{}) bool {
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	s, _ := stamp.(string)
+	return s == acc.SecurityStamp
+}
 
-		tokens, ok := req.Header["Authorization"]
-		if ok && len(tokens) >= 1 {
-			tokenString = tokens[0]
-			tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+// parseToken checks an access token and returns its claims. Fails if it
+// has expired or was revoked.
+func parseToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Don't forget to validate the alg is what you expect:
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Don't forget to validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
+		return mySigningKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
 
-			return mySigningKey, nil
-		})
+	email, ok := claims["email"].(string)
+	if !ok || !validSecurityStamp(email, claims["sstamp"]) {
+		return nil, errors.New("revoked token")
+	}
+	return claims, nil
+}
 
+// bearerToken returns the access token from the Authorization header
+func bearerToken(req *http.Request) string {
+	tokens, ok := req.Header["Authorization"]
+	if ok && len(tokens) >= 1 {
+		return strings.TrimPrefix(tokens[0], "Bearer ")
+	}
+	return ""
+}
+
+// withAccount adds the email and device of the token to the request context
+func withAccount(req *http.Request, claims jwt.MapClaims) *http.Request {
+	device, _ := claims["device"].(string)
+	ctx := context.WithValue(req.Context(), ctxKey("email"), claims["email"].(string))
+	ctx = context.WithValue(ctx, ctxKey("device"), device)
+	return req.WithContext(ctx)
+}
+
+func jwtMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		claims, err := parseToken(bearerToken(req))
 		if err != nil {
-			log.Println("JWT: " + err.Error()) // Fatal for now to catch all errors here
+			log.Println("JWT: " + err.Error())
 
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(http.StatusText(401)))
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			email, ok := claims["email"].(string)
-			if ok {
-				ctx := context.WithValue(req.Context(), ctxKey("email"), email)
-				next.ServeHTTP(w, req.WithContext(ctx))
-				return
-			}
-		}
-
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(http.StatusText(401)))
+		next.ServeHTTP(w, withAccount(req, claims))
 	})
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -16,7 +18,9 @@ func TestHandleLogin(t *testing.T) {
 	}{{url.Values{"grant_type": {"password"}, "username": {"nobody@example.com"}, "password": {"base64password"}}, 200},
 		{url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"abcdef"}}, 200},
 		{url.Values{"grant_type": {"password"}, "username": {"nobody@example.com"}, "password": {""}}, 401},
-		{url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"nasdfasdf"}}, 401}}
+		{url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"nasdfasdf"}}, 401},
+		{url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"abc"}}, 400},
+		{url.Values{"grant_type": {"refresh_token"}}, 400}}
 
 	db = &mockDB{username: "nobody@example.com", password: "base64password", refreshToken: "abcdef"}
 
@@ -38,3 +42,59 @@ func TestHandleLogin(t *testing.T) {
 	}
 
 }
+
+// The notifications of an account are published under its id, which the
+// clients compare with the subject of their token
+func TestLoginTokenSubject(t *testing.T) {
+	db = &mockDB{id: "2a1cb1d4-8a43-4cd6-b1cf-5a1a4b2f6f47", username: "nobody@example.com", password: "base64password"}
+
+	data := url.Values{"grant_type": {"password"}, "username": {"nobody@example.com"}, "password": {"base64password"}}
+	req, err := http.NewRequest("POST", "/identity/connect/token", strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	res := httptest.NewRecorder()
+	handleLogin(res, req)
+
+	var rtoken resToken
+	err = json.Unmarshal(res.Body.Bytes(), &rtoken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := parseToken(rtoken.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _ := claims["sub"].(string)
+
+	c := &hubClient{send: make(chan []byte, 1)}
+	hub.add(sub, c)
+	defer hub.remove(sub, c)
+
+	acc, _ := db.getAccount("nobody@example.com", "")
+	pushUser(req, pushSyncVault, acc.Id)
+
+	var msg struct {
+		Arguments []struct {
+			Payload struct {
+				UserId string
+			}
+		} `json:"arguments"`
+	}
+	select {
+	case m := <-c.send:
+		err = json.Unmarshal(bytes.TrimSuffix(m, []byte{recordSeparator}), &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("Expected a notification for the subject %v", sub)
+	}
+
+	if len(msg.Arguments) != 1 || msg.Arguments[0].Payload.UserId != sub {
+		t.Errorf("Expected UserId %v got %+v", sub, msg)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// Increase when the format changes in a way older versions can't read
+const backupVersion = 1
+
+// A backup of one or more accounts. The vault data is still encrypted by
+// the clients, but the file contains the master password hashes and keys
+// so it must be kept safe.
+type backup struct {
+	Version  int
+	Created  time.Time
+	Accounts []backupAccount
+}
+
+type backupAccount struct {
+	Account     Account
+	Folders     []Folder
+	Ciphers     []Cipher
+	Attachments map[string][]byte // Attachment id to file content
+}
+
+// exportBackup writes the account with the given email, or all accounts if
+// email is empty, to a json file.
+func exportBackup(path string, email string) error {
+	var accounts []Account
+	if email != "" {
+		acc, err := db.getAccount(email, "")
+		if err != nil {
+			return err
+		}
+		accounts = append(accounts, acc)
+	} else {
+		var err error
+		accounts, err = db.getAccounts()
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = writeBackup(f, accounts)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// writeBackup writes the accounts as a backup in json. The attachments are
+// copied from the blob store one at a time, so they don't have to fit in
+// memory. A bufio.Writer keeps the first write error and returns it from
+// Flush, so the writes in between aren't checked.
+func writeBackup(w io.Writer, accounts []Account) error {
+	bw := bufio.NewWriter(w)
+
+	created, err := json.Marshal(time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(bw, `{"Version":%d,"Created":%s,"Accounts":[`, backupVersion, created)
+
+	for i, acc := range accounts {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+
+		err = writeBackupAccount(bw, acc)
+		if err != nil {
+			return err
+		}
+	}
+
+	bw.WriteString("]}")
+	return bw.Flush()
+}
+
+// writeBackupAccount writes one account of a backup with its folders,
+// ciphers and the base64 encoded content of its attachments
+func writeBackupAccount(w *bufio.Writer, acc Account) error {
+	folders, err := db.getFolders(acc.Id)
+	if err != nil {
+		return err
+	}
+
+	ciphs, err := db.getCiphers(acc.Id)
+	if err != nil {
+		return err
+	}
+
+	var parts [3][]byte
+	for i, v := range []interface{}{acc, folders, ciphs} {
+		parts[i], err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, `{"Account":%s,"Folders":%s,"Ciphers":%s,"Attachments":{`, parts[0], parts[1], parts[2])
+
+	n := 0
+	for _, ciph := range ciphs {
+		for _, a := range ciph.Attachments {
+			id, err := json.Marshal(a.Id)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				w.WriteString(",")
+			}
+			n++
+			fmt.Fprintf(w, `%s:"`, id)
+
+			r, err := blobs.get(attachmentKey(ciph.Id, a.Id))
+			if err != nil {
+				return err
+			}
+			enc := base64.NewEncoder(base64.StdEncoding, w)
+			_, err = io.Copy(enc, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+			err = enc.Close()
+			if err != nil {
+				return err
+			}
+			w.WriteString(`"`)
+		}
+	}
+	w.WriteString("}}")
+
+	log.Printf("Exporting %s with %d folders and %d ciphers\n", acc.Email, len(folders), len(ciphs))
+	return nil
+}
+
+// importBackup restores all accounts in the file, or none of them if it
+// fails. The database must be empty. The whole file is read into memory, so
+// the attachments of all accounts need to fit.
+func importBackup(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var b backup
+	err = json.Unmarshal(data, &b)
+	if err != nil {
+		return err
+	}
+
+	if b.Version != backupVersion {
+		return fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+
+	existing, err := db.getAccounts()
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return errors.New("the database is not empty")
+	}
+
+	// Blobs of a failed import are removed again
+	var written []string
+	err = db.restoreAccounts(b.Accounts, func(ids map[string]string) error {
+		for _, ba := range b.Accounts {
+			for _, ciph := range ba.Ciphers {
+				for _, a := range ciph.Attachments {
+					content, ok := ba.Attachments[a.Id]
+					if !ok {
+						return fmt.Errorf("missing data for attachment %s", a.Id)
+					}
+
+					key := attachmentKey(ids[ciph.Id], a.Id)
+					written = append(written, key)
+					err := blobs.put(key, bytes.NewReader(content), int64(len(content)))
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, key := range written {
+			err := blobs.delete(key)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		return err
+	}
+
+	for _, ba := range b.Accounts {
+		log.Printf("Imported %s with %d folders and %d ciphers\n", ba.Account.Email, len(ba.Folders), len(ba.Ciphers))
+	}
+
+	return nil
+}
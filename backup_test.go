@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// backupTestVault fills the database with two accounts, one with a folder, a
+// cipher in the trash and an attachment
+func backupTestVault(t *testing.T, d *DB) {
+	acc := newTestAccount(t, d, "nobody@example.com")
+	newTestAccount(t, d, "other@example.com")
+
+	folder, err := d.addFolder(testEncString("folder"), acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciph, err := d.newCipher(Cipher{Type: 1, FolderId: &folder.Id, Data: CipherData{Name: testEncString("cipher")}}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.trashCiphers(acc.Id, []string{ciph.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("2.encrypted attachment data")
+	err = blobs.put(attachmentKey(ciph.Id, "attachment"), bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.addAttachment(Attachment{Id: "attachment", CipherId: ciph.Id, FileName: testEncString("file"), Key: testEncString("key"), Size: "27"}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.json")
+
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+	_, cleanupBlobs := useTestS3(t)
+	defer cleanupBlobs()
+
+	backupTestVault(t, d)
+	err = exportBackup(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Into an empty database and blob store
+	restored, cleanupRestored := useTestDB(t)
+	defer cleanupRestored()
+	store, cleanupStore := useTestS3(t)
+	defer cleanupStore()
+
+	err = importBackup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := restored.getAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || accounts[0].Email != "nobody@example.com" || accounts[0].Key != "key" || accounts[1].Email != "other@example.com" {
+		t.Fatalf("Expected both accounts got %+v", accounts)
+	}
+
+	folders, err := restored.getFolders(accounts[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 1 || folders[0].Name != testEncString("folder") {
+		t.Fatalf("Expected the folder got %+v", folders)
+	}
+
+	ciphs, err := restored.getCiphers(accounts[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 1 || ciphs[0].Data.Name != testEncString("cipher") || ciphs[0].FolderId == nil || *ciphs[0].FolderId != folders[0].Id || ciphs[0].DeletedDate == nil {
+		t.Fatalf("Expected the cipher in the folder and the trash got %+v", ciphs)
+	}
+	if len(ciphs[0].Attachments) != 1 || ciphs[0].Attachments[0].FileName != testEncString("file") || ciphs[0].Attachments[0].Key != testEncString("key") {
+		t.Fatalf("Expected the attachment got %+v", ciphs[0].Attachments)
+	}
+
+	r, err := blobs.get(attachmentKey(ciphs[0].Id, "attachment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "2.encrypted attachment data" {
+		t.Errorf("Expected the attachment data got %q %v", data, err)
+	}
+
+	// Only into an empty database
+	err = importBackup(path)
+	if err == nil {
+		t.Error("Expected an error importing into a database with accounts")
+	}
+	if len(store.objects) != 1 {
+		t.Errorf("Expected only the restored blob got %v", len(store.objects))
+	}
+}
+
+func TestBackupImportFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.json")
+
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+	_, cleanupBlobs := useTestS3(t)
+	defer cleanupBlobs()
+	backupTestVault(t, d)
+
+	var b backup
+	var buf bytes.Buffer
+	accounts, err := d.getAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeBackup(&buf, accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(buf.Bytes(), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second account has an attachment without data, so the blob of the
+	// first one is written before the import fails
+	b.Accounts[1].Ciphers = append(b.Accounts[1].Ciphers, Cipher{Type: 1, RevisionDate: time.Now(), Attachments: []Attachment{{Id: "missing", Size: "1"}}})
+	data, err := json.Marshal(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, cleanupRestored := useTestDB(t)
+	defer cleanupRestored()
+	store, cleanupStore := useTestS3(t)
+	defer cleanupStore()
+
+	err = importBackup(path)
+	if err == nil {
+		t.Fatal("Expected an error for the missing attachment data")
+	}
+
+	accounts, err = restored.getAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 0 || len(store.objects) != 0 {
+		t.Errorf("Expected nothing to be restored got %v accounts and %v blobs", len(accounts), len(store.objects))
+	}
+}
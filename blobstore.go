@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stores blobs as files under dir. The key is used as a relative path.
+type fsBlobStore struct {
+	dir string
+}
+
+func (s *fsBlobStore) path(key string) string {
+	// Don't allow keys to escape the directory
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		p = filepath.Base(p)
+		if p == "." || p == ".." || p == string(filepath.Separator) {
+			p = "_"
+		}
+		parts[i] = p
+	}
+	return filepath.Join(append([]string{s.dir}, parts...)...)
+}
+
+func (s *fsBlobStore) put(key string, r io.Reader, size int64) error {
+	path := s.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(f, r, size)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *fsBlobStore) get(key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *fsBlobStore) delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Files can only be downloaded through the server
+func (s *fsBlobStore) url(key string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type bulkCipherData struct {
+	Ids      []string `json:"ids"`
+	FolderId string   `json:"folderId"`
+}
+
+// This function handles /api/ciphers/move, /api/ciphers/delete, /api/ciphers/restore and /api/ciphers/share.
+// Either all of the ciphers are changed or none of them.
+func handleBulkCiphers(w http.ResponseWriter, req *http.Request, acc Account, action string) {
+	log.Println(acc.Email + " is trying to " + action + " ciphers")
+
+	var bulk bulkCipherData
+	err := json.NewDecoder(req.Body).Decode(&bulk)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+	bulk.Ids = uniqueIDs(bulk.Ids)
+
+	switch {
+	case action == "move" && (req.Method == "PUT" || req.Method == "POST"):
+		err = db.moveCiphers(acc.Id, bulk.Ids, nullString(bulk.FolderId))
+
+	case action == "delete" && req.Method == "PUT":
+		err = db.trashCiphers(acc.Id, bulk.Ids)
+
+	case action == "delete" && req.Method == "POST":
+		var attachments, a []Attachment
+		for _, id := range bulk.Ids {
+			a, err = db.getAttachments(acc.Id, id)
+			if err != nil {
+				break
+			}
+			attachments = append(attachments, a...)
+		}
+
+		if err == nil {
+			err = db.deleteCiphers(acc.Id, bulk.Ids)
+		}
+		if err == nil {
+			removeAttachmentBlobs(attachments)
+		}
+
+	case action == "restore" && req.Method == "PUT":
+		err = db.restoreCiphers(acc.Id, bulk.Ids)
+
+	case action == "share" && (req.Method == "PUT" || req.Method == "POST"):
+		// Sharing needs organizations and their collections
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println("Organizations are not supported")
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	if err == errCipherConflict {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err == errFolderNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(404)))
+		log.Println(err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println(err)
+		return
+	}
+	pushUser(req, pushSyncCiphers, acc.Id)
+
+	if action != "restore" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(""))
+		return
+	}
+
+	// Send the restored ciphers back
+	restored := make([]Cipher, 0, len(bulk.Ids))
+	for _, id := range bulk.Ids {
+		ciph, err := db.getCipher(acc.Id, id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(500)))
+			log.Println(err)
+			return
+		}
+		restored = append(restored, ciph)
+	}
+	setAttachmentURLs(restored)
+
+	writeList(w, restored)
+}
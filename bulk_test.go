@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleBulkCiphers(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+	store, cleanupBlobs := useTestBlobStore(t)
+	defer cleanupBlobs()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	other := newTestAccount(t, d, "other@example.com")
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		ciph, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ciph.Id)
+	}
+	foreign, err := d.newCipher(Cipher{Type: 1}, other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder, err := d.addFolder("name", acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFolder, err := d.addFolder("name", other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bulk := func(method string, action string, body string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handleCipherUpdate(res, testRequest(t, acc, method, "/api/ciphers/"+action, body))
+		return res
+	}
+	ciphers := func() []Cipher {
+		ciphs, err := d.getCiphers(acc.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ciphs
+	}
+
+	// Every id has to belong to the account, otherwise nothing is changed
+	res := bulk("PUT", "move", `{"ids":["`+ids[0]+`","`+foreign.Id+`"],"folderId":"`+folder.Id+`"}`)
+	if res.Code != 400 || findCipher(ciphers(), ids[0]).FolderId != nil {
+		t.Errorf("Expected 400 and no move with a cipher of another account got %v", res.Code)
+	}
+	res = bulk("PUT", "move", `{"ids":["`+ids[0]+`"],"folderId":"`+otherFolder.Id+`"}`)
+	if res.Code != 404 || findCipher(ciphers(), ids[0]).FolderId != nil {
+		t.Errorf("Expected 404 and no move to a folder of another account got %v", res.Code)
+	}
+	res = bulk("PUT", "move", `{"ids":["`+ids[0]+`","`+ids[1]+`","`+ids[0]+`"],"folderId":"`+folder.Id+`"}`)
+	if res.Code != 200 {
+		t.Fatalf("Expected 200 for a move got %v", res.Code)
+	}
+	for _, ciph := range ciphers() {
+		if ciph.FolderId == nil || *ciph.FolderId != folder.Id {
+			t.Errorf("Expected cipher %v in folder %v got %v", ciph.Id, folder.Id, ciph.FolderId)
+		}
+	}
+
+	res = bulk("PUT", "delete", `{"ids":["`+ids[0]+`","`+foreign.Id+`"]}`)
+	if res.Code != 400 || findCipher(ciphers(), ids[0]).DeletedDate != nil {
+		t.Errorf("Expected 400 and nothing in the trash got %v", res.Code)
+	}
+	res = bulk("PUT", "delete", `{"ids":["`+ids[0]+`"]}`)
+	if res.Code != 200 || findCipher(ciphers(), ids[0]).DeletedDate == nil {
+		t.Errorf("Expected the cipher in the trash got %v", res.Code)
+	}
+
+	// The restored ciphers are sent back once
+	res = bulk("PUT", "restore", `{"ids":["`+ids[0]+`","`+ids[0]+`"]}`)
+	if res.Code != 200 {
+		t.Fatalf("Expected 200 for a restore got %v", res.Code)
+	}
+	var restored struct {
+		Data   []Cipher
+		Object string
+	}
+	err = json.Unmarshal(res.Body.Bytes(), &restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Object != "list" || len(restored.Data) != 1 || restored.Data[0].Id != ids[0] || restored.Data[0].DeletedDate != nil {
+		t.Errorf("Expected the restored cipher got %s", res.Body.Bytes())
+	}
+
+	// A permanent delete removes the attachment blobs
+	data := []byte("2.encrypted attachment data")
+	err = store.put(attachmentKey(ids[1], "attachment"), bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.addAttachment(Attachment{Id: "attachment", CipherId: ids[1], Size: "27"}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = bulk("POST", "delete", `{"ids":["`+ids[1]+`","`+foreign.Id+`"]}`)
+	if res.Code != 400 || len(ciphers()) != 2 {
+		t.Errorf("Expected 400 and no delete with a cipher of another account got %v", res.Code)
+	}
+	res = bulk("POST", "delete", `{"ids":["`+ids[1]+`"]}`)
+	if res.Code != 200 || len(ciphers()) != 1 {
+		t.Errorf("Expected the cipher to be deleted got %v", res.Code)
+	}
+	_, err = store.get(attachmentKey(ids[1], "attachment"))
+	if err == nil {
+		t.Error("Expected the attachment blob to be removed")
+	}
+
+	res = bulk("PUT", "share", `{"ciphers":[],"collectionIds":[]}`)
+	if res.Code != 400 {
+		t.Errorf("Expected 400 for sharing got %v", res.Code)
+	}
+}
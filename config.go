@@ -6,3 +6,38 @@ var jwtExpire = 3600
 var db database = &DB{}
 
 const serverAddr = ":8000"
+
+// Address the clients use to reach the server, used to build attachment urls
+var baseURL = "http://localhost" + serverAddr
+
+// Attachments are stored as files under attachmentsDir. To store them in an
+// S3 compatible bucket instead use something like:
+//
+//	var blobs blobStore = &s3BlobStore{endpoint: "http://localhost:9000", region: "us-east-1",
+//		bucket: "attachments", accessKey: "...", secretKey: "...", presignExpire: 5 * time.Minute}
+var attachmentsDir = "attachments"
+var blobs blobStore = &fsBlobStore{dir: attachmentsDir}
+var maxAttachmentSize int64 = 100 << 20 // 100 MB
+
+// Number of old passwords kept for each login
+var maxPasswordHistory = 5
+
+// Ciphers in the trash are permanently deleted after this many days, 0 keeps them forever
+var trashRetentionDays = 30
+
+// Number of previous versions kept for each cipher
+var maxCipherRevisions = 10
+
+// Default storage limits of an account, 0 means unlimited. They can be
+// changed for an account with -quota.
+var defaultQuotaItems int64 = 10000
+var defaultQuotaDataBytes int64 = 50 << 20      // 50 MB
+var defaultQuotaAttachmentBytes int64 = 1 << 30 // 1 GB
+
+// Equivalent domains for all users are read from this json file if set,
+// instead of using the built-in list
+var globalDomainsFile = ""
+
+// Limits for /api/ciphers/import
+var maxImportItems = 10000
+var maxImportSize int64 = 64 << 20 // 64 MB
@@ -3,7 +3,10 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"strconv"
 	"time"
 
@@ -15,45 +18,262 @@ type DB struct {
 	db *sql.DB
 }
 
+// Returned when a cipher is stored in a folder that doesn't belong to the owner
+var errFolderNotFound = errors.New("folder not found")
+
+// Returned when a cipher was changed by another client since the update was made
+var errCipherConflict = errors.New("The cipher you are updating is out of date. Please save your work, sync your vault, and try again.")
+
 func (db *DB) init() error {
-	query1 := "CREATE TABLE \"accounts\" ( `id` INTEGER, `name` TEXT, `email` TEXT UNIQUE, `masterPasswordHash` NUMERIC, `masterPasswordHint` TEXT, `key` TEXT, 'refreshtoken' TEXT, PRIMARY KEY(id) );"
-	query2 := "CREATE TABLE \"ciphers\" ( `id` INTEGER PRIMARY KEY AUTOINCREMENT, `type` INTEGER, `revisiondate` INTEGER, `data` BLOB, `owner` INTEGER );"
-	query3 := "CREATE TABLE \"folders\" (`id`	TEXT,	`name`	TEXT,	`revisiondate`	INTEGER,	`owner`	INTEGER, PRIMARY KEY(id))"
-	stmt1, err := db.db.Prepare(query1)
+	for _, query := range schema {
+		stmt, err := db.db.Prepare(query)
+		if err != nil {
+			return err
+		}
+
+		_, err = stmt.Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var schema = append([]string{
+	"CREATE TABLE \"accounts\" ( `id` TEXT, `name` TEXT, `email` TEXT UNIQUE, `masterPasswordHash` NUMERIC, `masterPasswordHint` TEXT, `key` TEXT, 'refreshtoken' TEXT, `privatekey` TEXT NOT NULL DEFAULT '', `securitystamp` TEXT NOT NULL DEFAULT '', `quotaitems` INTEGER, `quotadatabytes` INTEGER, `quotaattachmentbytes` INTEGER, `revisiondate` INTEGER NOT NULL DEFAULT 0, `equivalentdomains` TEXT NOT NULL DEFAULT '[]', `excludedglobaldomains` TEXT NOT NULL DEFAULT '[]', PRIMARY KEY(id) );",
+	"CREATE TABLE \"ciphers\" ( `id` TEXT, `type` INTEGER, `revisiondate` INTEGER, `data` BLOB, `owner` TEXT, `folderid` TEXT, `organizationid` TEXT, `passwordhistory` BLOB, `deleteddate` INTEGER, `device` TEXT, `reprompt` INTEGER NOT NULL DEFAULT 0, PRIMARY KEY(id) );",
+	"CREATE TABLE \"folders\" (`id`	TEXT,	`name`	TEXT,	`revisiondate`	INTEGER,	`owner`	TEXT, PRIMARY KEY(id))",
+	"CREATE TABLE \"legacy_ids\" ( `kind` TEXT, `oldid` TEXT, `newid` TEXT, `blobsmoved` INTEGER NOT NULL DEFAULT 0, PRIMARY KEY(kind, oldid) );",
+}, addedTables...)
+
+// Tables added after the first version. migrate creates them in older
+// databases.
+var addedTables = []string{
+	"CREATE TABLE IF NOT EXISTS \"favorites\" ( `cipher` TEXT, `user` TEXT, PRIMARY KEY(cipher, user) );",
+	"CREATE TABLE IF NOT EXISTS \"cipher_revisions\" ( `id` TEXT, `cipher` TEXT, `type` INTEGER, `data` BLOB, `revisiondate` INTEGER, `device` TEXT, PRIMARY KEY(id) );",
+	"CREATE TABLE IF NOT EXISTS \"attachments\" ( `id` TEXT, `cipher` TEXT, `filename` TEXT, `key` TEXT, `size` INTEGER, PRIMARY KEY(id) );",
+}
+
+// The tables that used integer ids for ciphers or accounts and how to copy
+// them with the new ids. l maps the row's own id, o the owner and u the user.
+var legacyTables = map[string]string{
+	"accounts":         "INSERT INTO accounts(id, name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken) SELECT l.newid, a.name, a.email, a.masterPasswordHash, a.masterPasswordHint, a.key, a.refreshtoken FROM old_accounts a JOIN legacy_ids l ON l.kind = 'account' AND l.oldid = CAST(a.id AS TEXT)",
+	"ciphers":          "INSERT INTO ciphers(id, type, revisiondate, data, owner, folderid, organizationid, passwordhistory, deleteddate, device) SELECT l.newid, c.type, c.revisiondate, c.data, o.newid, c.folderid, c.organizationid, c.passwordhistory, c.deleteddate, c.device FROM old_ciphers c JOIN legacy_ids l ON l.kind = 'cipher' AND l.oldid = CAST(c.id AS TEXT) JOIN legacy_ids o ON o.kind = 'account' AND o.oldid = CAST(c.owner AS TEXT)",
+	"folders":          "INSERT INTO folders(id, name, revisiondate, owner) SELECT f.id, f.name, f.revisiondate, o.newid FROM old_folders f JOIN legacy_ids o ON o.kind = 'account' AND o.oldid = CAST(f.owner AS TEXT)",
+	"favorites":        "INSERT INTO favorites(cipher, user) SELECT l.newid, u.newid FROM old_favorites f JOIN legacy_ids l ON l.kind = 'cipher' AND l.oldid = CAST(f.cipher AS TEXT) JOIN legacy_ids u ON u.kind = 'account' AND u.oldid = CAST(f.user AS TEXT)",
+	"cipher_revisions": "INSERT INTO cipher_revisions(id, cipher, type, data, revisiondate, device) SELECT r.id, l.newid, r.type, r.data, r.revisiondate, r.device FROM old_cipher_revisions r JOIN legacy_ids l ON l.kind = 'cipher' AND l.oldid = CAST(r.cipher AS TEXT)",
+	"attachments":      "INSERT INTO attachments(id, cipher, filename, key, size) SELECT a.id, l.newid, a.filename, a.key, a.size FROM old_attachments a JOIN legacy_ids l ON l.kind = 'cipher' AND l.oldid = CAST(a.cipher AS TEXT)",
+}
+
+// Columns added to the tables after they were first created. Older
+// databases get them with their default value.
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"ciphers", "folderid", "TEXT"},
+	{"ciphers", "organizationid", "TEXT"},
+	{"ciphers", "passwordhistory", "BLOB"},
+	{"ciphers", "deleteddate", "INTEGER"},
+	{"ciphers", "device", "TEXT"},
+	{"accounts", "privatekey", "TEXT NOT NULL DEFAULT ''"},
+	{"accounts", "securitystamp", "TEXT NOT NULL DEFAULT ''"},
+	{"ciphers", "reprompt", "INTEGER NOT NULL DEFAULT 0"},
+	{"accounts", "quotaitems", "INTEGER"},
+	{"accounts", "quotadatabytes", "INTEGER"},
+	{"accounts", "quotaattachmentbytes", "INTEGER"},
+	{"accounts", "revisiondate", "INTEGER NOT NULL DEFAULT 0"},
+	{"accounts", "equivalentdomains", "TEXT NOT NULL DEFAULT '[]'"},
+	{"accounts", "excludedglobaldomains", "TEXT NOT NULL DEFAULT '[]'"},
+}
+
+// migrate updates a database created by an older version
+func (db *DB) migrate() error {
+	// Not initialized yet
+	var n int
+	err := db.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name='ciphers'").Scan(&n)
+	if err != nil || n == 0 {
+		return err
+	}
+
+	// Before the switch to UUIDs, which copies these columns
+	for _, c := range addedColumns {
+		err = db.db.QueryRow("SELECT count(*) FROM pragma_table_info($1) WHERE name=$2", c.table, c.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		_, err = db.db.Exec("ALTER TABLE " + c.table + " ADD COLUMN " + c.column + " " + c.definition)
+		if err != nil {
+			return err
+		}
+		log.Println("Added column " + c.column + " to " + c.table)
+	}
+
+	err = db.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name='legacy_ids'").Scan(&n)
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		err = db.migrateUUIDs()
+		if err != nil {
+			return err
+		}
+	}
 
-	_, err = stmt1.Exec()
+	for _, query := range addedTables {
+		_, err = db.db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Cipher revision dates used to be stored in seconds. Anything below
+	// 10^11 is a date in seconds, in milliseconds it would be from 1973.
+	for _, table := range []string{"ciphers", "cipher_revisions"} {
+		res, err := db.db.Exec("UPDATE " + table + " SET revisiondate=revisiondate*1000 WHERE revisiondate < 100000000000")
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("Switched %d revision dates in %s to milliseconds\n", n, table)
+		}
+	}
+
+	// Accounts from before they had a revision date, the clients sync once
+	_, err = db.db.Exec("UPDATE accounts SET revisiondate=$1 WHERE revisiondate=0", unixMilli(time.Now()))
 	if err != nil {
 		return err
 	}
 
-	stmt2, err := db.db.Prepare(query2)
+	return nil
+}
+
+// Account and cipher revision dates are stored in milliseconds so that a
+// change right after a sync or an update isn't missed
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// migrateUUIDs converts a database from before ciphers and accounts had
+// UUIDs. Every row gets a new id and the old ones are kept in legacy_ids so
+// they can still be resolved with legacyID.
+func (db *DB) migrateUUIDs() error {
+	tx, err := db.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = stmt2.Exec()
+	// Older databases may not have all of the tables yet
+	var tables []string
+	for table := range legacyTables {
+		var n int
+		err = tx.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name=$1", table).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+
+		_, err = tx.Exec("ALTER TABLE " + table + " RENAME TO old_" + table)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, table)
+	}
+
+	for _, query := range schema {
+		_, err = tx.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	accounts, err := newLegacyIDs(tx, "account", "SELECT id FROM old_accounts")
 	if err != nil {
 		return err
 	}
 
-	stmt3, err := db.db.Prepare(query3)
+	ids, err := newLegacyIDs(tx, "cipher", "SELECT id FROM old_ciphers")
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt3.Exec()
+	for _, table := range tables {
+		_, err = tx.Exec(legacyTables[table])
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DROP TABLE old_" + table)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
-	return err
+
+	log.Printf("Migrated %d accounts and %d ciphers to UUIDs\n", len(accounts), len(ids))
+	return nil
+}
+
+// newLegacyIDs gives a new UUID to each of the ids returned by the query and
+// stores them in legacy_ids
+func newLegacyIDs(tx *sql.Tx, kind string, query string) (map[string]string, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var oldIDs []string
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		oldIDs = append(oldIDs, id)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	ids := make(map[string]string)
+	for _, id := range oldIDs {
+		ids[id] = uuid.NewV4().String()
+		_, err = tx.Exec("INSERT INTO legacy_ids(kind, oldid, newid) values(?,?,?)", kind, id, ids[id])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
 }
 
 func (db *DB) open() error {
+	return db.openFile("db")
+}
+
+// openFile opens the database at path. Transactions take the write lock when
+// they begin, otherwise one that reads before it writes fails with
+// SQLITE_BUSY if another one wrote in between.
+func (db *DB) openFile(path string) error {
 	var err error
-	db.db, err = sql.Open("sqlite3", "db")
+	db.db, err = sql.Open("sqlite3", path+"?_txlock=immediate")
 	return err
 }
 
@@ -62,28 +282,56 @@ func (db *DB) close() {
 }
 
 func (db *DB) getCiphers(owner string) ([]Cipher, error) {
-	iowner, err := strconv.ParseInt(owner, 10, 64)
+	return db.queryCiphers(owner, "")
+}
+
+func (db *DB) getCipher(owner string, ciphID string) (Cipher, error) {
+	ciphers, err := db.queryCiphers(owner, ciphID)
+	if err != nil {
+		return Cipher{}, err
+	}
+
+	if len(ciphers) != 1 {
+		return Cipher{}, sql.ErrNoRows
+	}
+	return ciphers[0], nil
+}
+
+// queryCiphers returns the owners ciphers, or only the one with ciphID if
+// it's not empty
+func (db *DB) queryCiphers(owner string, ciphID string) ([]Cipher, error) {
+	ciphID, err := legacyID(db.db, "cipher", ciphID)
 	if err != nil {
 		return nil, err
 	}
 
 	var ciphers []Cipher
-	query := "SELECT id, type, revisiondate, data FROM ciphers WHERE owner = $1"
-	rows, err := db.db.Query(query, iowner)
+	query := "SELECT c.id, c.type, c.revisiondate, c.data, c.folderid, c.organizationid, c.passwordhistory, c.deleteddate, c.reprompt, f.cipher IS NOT NULL FROM ciphers c LEFT JOIN favorites f ON f.cipher = c.id AND f.user = c.owner WHERE c.owner = $1"
+	args := []interface{}{owner}
+	if ciphID != "" {
+		query += " AND c.id = $2"
+		args = append(args, ciphID)
+	}
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	var iid int
 	var revDate int64
-	var blob []byte
+	var blob, history []byte
+	var folderID, orgID sql.NullString
+	var delDate sql.NullInt64
 	for rows.Next() {
 		ciph := Cipher{
-			Favorite:            false,
 			Edit:                true,
 			OrganizationUseTotp: false,
 			Object:              "cipher",
 			Attachments:         nil,
 		}
 
-		err := rows.Scan(&iid, &ciph.Type, &revDate, &blob)
+		err := rows.Scan(&ciph.Id, &ciph.Type, &revDate, &blob, &folderID, &orgID, &history, &delDate, &ciph.Reprompt, &ciph.Favorite)
 		if err != nil {
 			return nil, err
 		}
@@ -91,211 +339,1371 @@ func (db *DB) getCiphers(owner string) ([]Cipher, error) {
 		if err != nil {
 			return nil, err
 		}
-		ciph.Id = strconv.Itoa(iid)
-		ciph.RevisionDate = time.Unix(revDate, 0)
+		if len(history) > 0 {
+			err = json.Unmarshal(history, &ciph.PasswordHistory)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ciph.RevisionDate = time.Unix(0, revDate*int64(time.Millisecond))
+		ciph.FolderId = nullString(folderID.String)
+		ciph.OrganizationId = nullString(orgID.String)
+		if delDate.Valid {
+			deleted := time.Unix(delDate.Int64, 0)
+			ciph.DeletedDate = &deleted
+		}
 
 		ciphers = append(ciphers, ciph)
 	}
 
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := db.getAttachments(owner, ciphID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ciphers {
+		for _, a := range attachments {
+			if a.CipherId == ciphers[i].Id {
+				ciphers[i].Attachments = append(ciphers[i].Attachments, a)
+			}
+		}
+	}
+
 	if len(ciphers) < 1 {
 		ciphers = make([]Cipher, 0) // Make an empty slice if there are none or android app will crash
 	}
-	return ciphers, err
+	return ciphers, nil
 }
 
 func (db *DB) newCipher(ciph Cipher, owner string) (Cipher, error) {
-	iowner, err := strconv.ParseInt(owner, 10, 64)
+	tx, err := db.db.Begin()
 	if err != nil {
-		return Cipher{}, err
+		return ciph, err
 	}
+	defer tx.Rollback()
 
-	ciph.RevisionDate = time.Now()
+	before, err := usage(tx, owner)
+	if err != nil {
+		return ciph, err
+	}
+
+	ciph, err = newCipherTx(tx, ciph, owner)
+	if err != nil {
+		return ciph, err
+	}
+
+	err = checkQuota(tx, owner, before)
+	if err != nil {
+		return ciph, err
+	}
 
-	stmt, err := db.db.Prepare("INSERT INTO ciphers(type, revisiondate, data, owner) values(?,?,?, ?)")
+	err = touchAccount(tx, owner)
 	if err != nil {
 		return ciph, err
 	}
 
+	return ciph, tx.Commit()
+}
+
+func newCipherTx(tx *sql.Tx, ciph Cipher, owner string) (Cipher, error) {
+	ciph.Id = uuid.NewV4().String()
+	ciph.RevisionDate = time.Now()
+
 	data, err := ciph.Data.bytes()
 	if err != nil {
 		return ciph, err
 	}
 
-	res, err := stmt.Exec(ciph.Type, ciph.RevisionDate.Unix(), data, iowner)
+	history, err := ciph.passwordHistoryBytes()
+	if err != nil {
+		return ciph, err
+	}
+
+	err = checkFolder(tx, ciph.FolderId, owner)
 	if err != nil {
 		return ciph, err
 	}
 
-	lID, err := res.LastInsertId()
-	ciph.Id = fmt.Sprintf("%v", lID)
+	_, err = tx.Exec("INSERT INTO ciphers(id, type, revisiondate, data, owner, folderid, organizationid, passwordhistory, device, reprompt) values(?,?,?,?,?,?,?,?,?,?)", ciph.Id, ciph.Type, unixMilli(ciph.RevisionDate), data, owner, ciph.FolderId, ciph.OrganizationId, history, ciph.Device, ciph.Reprompt)
+	if err != nil {
+		return ciph, err
+	}
 
-	return ciph, nil
+	err = setFavorite(tx, ciph.Id, owner, ciph.Favorite)
+	if err != nil {
+		return ciph, err
+	}
 
+	return ciph, nil
 }
 
-// Important to check that the owner is correct before an update!
-func (db *DB) updateCipher(newData Cipher, owner string, ciphID string) error {
-	iowner, err := strconv.ParseInt(owner, 10, 64)
+// importVault adds the folders and ciphers in one transaction. folderOf maps
+// the index of a cipher to the index of the folder it should be put in.
+func (db *DB) importVault(folders []Folder, ciphs []Cipher, folderOf map[int]int, owner string) error {
+	tx, err := db.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	iciphID, err := strconv.ParseInt(ciphID, 10, 64)
+	before, err := usage(tx, owner)
 	if err != nil {
 		return err
 	}
 
-	stmt, err := db.db.Prepare("UPDATE ciphers SET type=$1, revisiondate=$2, data=$3 WHERE id=$4 AND owner=$5")
-	if err != nil {
-		return err
+	now := time.Now()
+	for i := range folders {
+		folders[i].Id = uuid.NewV4().String()
+		_, err = tx.Exec("INSERT INTO folders(id, name, revisiondate, owner) values(?,?,?,?)", folders[i].Id, folders[i].Name, now.Unix(), owner)
+		if err != nil {
+			return err
+		}
 	}
 
-	bdata, err := newData.Data.bytes()
+	for i, ciph := range ciphs {
+		ciph.FolderId = nil
+		if f, ok := folderOf[i]; ok {
+			if f < 0 || f >= len(folders) {
+				return fmt.Errorf("invalid folder index %d", f)
+			}
+			ciph.FolderId = &folders[f].Id
+		}
+
+		_, err = newCipherTx(tx, ciph, owner)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkQuota(tx, owner, before)
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(newData.Type, time.Now().Unix(), bdata, iciphID, iowner)
+	err = touchAccount(tx, owner)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 // Important to check that the owner is correct before an update!
-func (db *DB) deleteCipher(owner string, ciphID string) error {
-	iowner, err := strconv.ParseInt(owner, 10, 64)
+func (db *DB) updateCipher(newData Cipher, owner string, ciphID string) error {
+	ciphID, err := legacyID(db.db, "cipher", ciphID)
 	if err != nil {
 		return err
 	}
 
-	iciphID, err := strconv.ParseInt(ciphID, 10, 64)
+	tx, err := db.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	stmt, err := db.db.Prepare("DELETE from ciphers WHERE id=$1 AND owner=$2")
+	before, err := usage(tx, owner)
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(iciphID, iowner)
+	err = updateCipherTx(tx, newData, owner, ciphID)
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-func (db *DB) addAccount(acc Account) error {
-	stmt, err := db.db.Prepare("INSERT INTO accounts(name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken) values(?,?,?,?,?, ?)")
+	err = checkQuota(tx, owner, before)
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(acc.Name, acc.Email, acc.MasterPasswordHash, acc.MasterPasswordHint, acc.Key, "")
+	err = touchAccount(tx, owner)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (db *DB) updateAccountInfo(sid string, refreshToken string) error {
-	id, err := strconv.ParseInt(sid, 10, 64)
+func updateCipherTx(tx *sql.Tx, newData Cipher, owner string, ciphID string) error {
+	bdata, err := newData.Data.bytes()
 	if err != nil {
 		return err
 	}
 
-	stmt, err := db.db.Prepare("UPDATE accounts SET refreshtoken=$1 WHERE id=$2")
+	history, err := newData.passwordHistoryBytes()
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(refreshToken, id)
+	err = checkFolder(tx, newData.FolderId, owner)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// Archiving fails if the cipher has changed since lastKnown. The
+	// transaction keeps it from changing until the update is done.
+	err = archiveCipher(tx, ciphID, owner, newData.LastKnownRevisionDate)
+	if err != nil {
+		return err
+	}
+
+	// Always after the archived revision, or an update based on it that is
+	// made in the same millisecond wouldn't be a conflict
+	_, err = tx.Exec("UPDATE ciphers SET type=$1, revisiondate=MAX($2, revisiondate+1), data=$3, folderid=$4, organizationid=$5, passwordhistory=$6, device=$7, reprompt=$8 WHERE id=$9 AND owner=$10", newData.Type, unixMilli(time.Now()), bdata, newData.FolderId, newData.OrganizationId, history, newData.Device, newData.Reprompt, ciphID, owner)
+	if err != nil {
+		return err
+	}
+
+	return setFavorite(tx, ciphID, owner, newData.Favorite)
 }
 
-func (db *DB) getAccount(username string, refreshtoken string) (Account, error) {
-	var row *sql.Row
-	acc := Account{}
-	if username != "" {
-		query := "SELECT * FROM accounts WHERE email = $1"
-		row = db.db.QueryRow(query, username)
+// updateCipherPartial only changes the folder and favorite, which aren't
+// encrypted. Important to check that the owner is correct before an update!
+func (db *DB) updateCipherPartial(owner string, ciphID string, folderID *string, favorite bool) error {
+	ciphID, err := legacyID(db.db, "cipher", ciphID)
+	if err != nil {
+		return err
 	}
-	if refreshtoken != "" {
-		query := "SELECT * FROM accounts WHERE refreshtoken = $1"
-		row = db.db.QueryRow(query, refreshtoken)
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	var iid int
-	err := row.Scan(&iid, &acc.Name, &acc.Email, &acc.MasterPasswordHash, &acc.MasterPasswordHint, &acc.Key, &acc.RefreshToken)
+	err = checkFolder(tx, folderID, owner)
 	if err != nil {
-		return acc, err
+		return err
 	}
 
-	acc.Id = strconv.Itoa(iid)
+	err = updateOwnedCiphers(tx, "UPDATE ciphers SET folderid=$1, revisiondate=$2 WHERE id=$3 AND owner=$4", []string{ciphID}, owner, folderID, unixMilli(time.Now()))
+	if err != nil {
+		return err
+	}
 
-	return acc, nil
-}
+	err = setFavorite(tx, ciphID, owner, favorite)
+	if err != nil {
+		return err
+	}
 
-func (db *DB) addFolder(name string, owner string) (Folder, error) {
-	iowner, err := strconv.ParseInt(owner, 10, 64)
+	err = touchAccount(tx, owner)
 	if err != nil {
-		return Folder{}, err
+		return err
 	}
 
-	folder := Folder{
-		Id:           uuid.NewV4().String(),
-		Name:         name,
-		Object:       "folder",
-		RevisionDate: time.Now(),
+	return tx.Commit()
+}
+
+// archiveCipher stores the current version of the cipher as a revision and
+// removes the revisions older than the last maxCipherRevisions. Returns
+// sql.ErrNoRows if the cipher doesn't belong to the owner, or
+// errCipherConflict if lastKnown is set and the cipher has been changed
+// after it.
+func archiveCipher(tx *sql.Tx, ciphID string, owner string, lastKnown *time.Time) error {
+	maxRevDate := int64(math.MaxInt64)
+	if lastKnown != nil {
+		maxRevDate = unixMilli(*lastKnown)
 	}
 
-	stmt, err := db.db.Prepare("INSERT INTO folders(id, name, revisiondate, owner) values(?,?,?, ?)")
+	res, err := tx.Exec("INSERT INTO cipher_revisions(id, cipher, type, data, revisiondate, device) SELECT $1, id, type, data, revisiondate, device FROM ciphers WHERE id=$2 AND owner=$3 AND revisiondate <= $4", uuid.NewV4().String(), ciphID, owner, maxRevDate)
 	if err != nil {
-		return Folder{}, err
+		return err
 	}
 
-	_, err = stmt.Exec(folder.Id, folder.Name, folder.RevisionDate.Unix(), iowner)
+	n, err := res.RowsAffected()
 	if err != nil {
-		return Folder{}, err
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM ciphers WHERE id=$1 AND owner=$2)", ciphID, owner).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errCipherConflict
+		}
+		return sql.ErrNoRows
 	}
 
-	return folder, nil
+	_, err = tx.Exec("DELETE FROM cipher_revisions WHERE cipher=$1 AND id NOT IN (SELECT id FROM cipher_revisions WHERE cipher=$1 ORDER BY revisiondate DESC, rowid DESC LIMIT $2)", ciphID, maxCipherRevisions)
+	return err
 }
 
-func (db *DB) getFolders(owner string) ([]Folder, error) {
-	iowner, err := strconv.ParseInt(owner, 10, 64)
+func (db *DB) getCipherRevisions(owner string, ciphID string) ([]CipherRevision, error) {
+	ciphID, err := legacyID(db.db, "cipher", ciphID)
 	if err != nil {
 		return nil, err
 	}
 
-	var folders []Folder
-	query := "SELECT id, name, revisiondate FROM folders WHERE owner = $1"
-	rows, err := db.db.Query(query, iowner)
+	query := "SELECT r.id, r.type, r.data, r.revisiondate, r.device FROM cipher_revisions r JOIN ciphers c ON r.cipher = c.id WHERE c.id = $1 AND c.owner = $2 ORDER BY r.revisiondate DESC, r.rowid DESC"
+	rows, err := db.db.Query(query, ciphID, owner)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
+	revisions := make([]CipherRevision, 0)
 	var revDate int64
+	var blob []byte
+	var device sql.NullString
 	for rows.Next() {
-		f := Folder{}
-		err := rows.Scan(&f.Id, &f.Name, &revDate)
+		rev := CipherRevision{CipherId: ciphID, Object: "cipherRevision"}
+		err := rows.Scan(&rev.Id, &rev.Type, &blob, &revDate, &device)
 		if err != nil {
 			return nil, err
 		}
-		f.RevisionDate = time.Unix(revDate, 0)
+		err = json.Unmarshal(blob, &rev.Data)
+		if err != nil {
+			return nil, err
+		}
+		rev.RevisionDate = time.Unix(0, revDate*int64(time.Millisecond))
+		rev.Device = device.String
 
-		folders = append(folders, f)
+		revisions = append(revisions, rev)
 	}
 
-	if len(folders) < 1 {
-		folders = make([]Folder, 0) // Make an empty slice if there are none or android app will crash
+	return revisions, rows.Err()
+}
+
+// restoreCipherRevision makes a revision the current version of the cipher.
+// The version it replaces is kept as a revision.
+// Important to check that the owner is correct before an update!
+func (db *DB) restoreCipherRevision(owner string, ciphID string, revID string, device string) error {
+	ciphID, err := legacyID(db.db, "cipher", ciphID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var typ int
+	var data []byte
+	err = tx.QueryRow("SELECT type, data FROM cipher_revisions WHERE id=$1 AND cipher=$2", revID, ciphID).Scan(&typ, &data)
+	if err != nil {
+		return err
+	}
+
+	err = archiveCipher(tx, ciphID, owner, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE ciphers SET type=$1, data=$2, revisiondate=MAX($3, revisiondate+1), device=$4 WHERE id=$5 AND owner=$6", typ, data, unixMilli(time.Now()), device, ciphID, owner)
+	if err != nil {
+		return err
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
 	}
-	return folders, err
+
+	return tx.Commit()
+}
+
+// checkFolder returns errFolderNotFound if the folder doesn't belong to the
+// owner. Ciphers without a folder are always fine.
+func checkFolder(tx *sql.Tx, folderID *string, owner string) error {
+	if folderID == nil {
+		return nil
+	}
+
+	var n int
+	err := tx.QueryRow("SELECT count(*) FROM folders WHERE id=$1 AND owner=$2", *folderID, owner).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errFolderNotFound
+	}
+	return nil
+}
+
+// Favorites are stored per user and not in the cipher itself
+func setFavorite(tx *sql.Tx, ciphID string, user string, favorite bool) error {
+	if !favorite {
+		_, err := tx.Exec("DELETE FROM favorites WHERE cipher=$1 AND user=$2", ciphID, user)
+		return err
+	}
+
+	_, err := tx.Exec("INSERT OR IGNORE INTO favorites(cipher, user) values(?,?)", ciphID, user)
+	return err
+}
+
+// touchAccount sets the revision date of the account to now. Clients compare
+// it to the time of their last sync to know if they have to sync again.
+func touchAccount(tx *sql.Tx, owner string) error {
+	_, err := tx.Exec("UPDATE accounts SET revisiondate=$1 WHERE id=$2", unixMilli(time.Now()), owner)
+	return err
+}
+
+// Either a *sql.DB or a *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Ciphers and accounts used to have integer ids. legacyID returns the UUID
+// that replaced such an id, so clients that still have the old ids keep
+// working, or the id itself if it isn't an old one.
+func legacyID(q queryer, kind string, id string) (string, error) {
+	var newID string
+	err := q.QueryRow("SELECT newid FROM legacy_ids WHERE kind=$1 AND oldid=$2", kind, id).Scan(&newID)
+	if err == sql.ErrNoRows {
+		return id, nil
+	}
+	return newID, err
+}
+
+// legacyCipherIDs looks up all the ids with legacyID
+func legacyCipherIDs(q queryer, ciphIDs []string) ([]string, error) {
+	// The second delete of a cipher wouldn't find it
+	ciphIDs = uniqueIDs(ciphIDs)
+
+	ids := make([]string, len(ciphIDs))
+	for i, id := range ciphIDs {
+		newID, err := legacyID(q, "cipher", id)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = newID
+	}
+	return ids, nil
+}
+
+// uniqueIDs returns the ids without duplicates, in the same order
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// updateOwnedCiphers runs query once for each cipher with args followed by
+// the cipher id and owner. Fails if any of the ciphers doesn't belong to the
+// owner, in which case nothing should be committed.
+func updateOwnedCiphers(tx *sql.Tx, query string, ciphIDs []string, owner string, args ...interface{}) error {
+	for _, id := range ciphIDs {
+		res, err := tx.Exec(query, append(args, id, owner)...)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("cipher %v not found", id)
+		}
+	}
+	return nil
+}
+
+// Important to check that the owner is correct before an update!
+func (db *DB) deleteCiphers(owner string, ciphIDs []string) error {
+	ids, err := legacyCipherIDs(db.db, ciphIDs)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateOwnedCiphers(tx, "DELETE from ciphers WHERE id=$1 AND owner=$2", ids, owner)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err = tx.Exec("DELETE from favorites WHERE cipher=$1", id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE from attachments WHERE cipher=$1", id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE from cipher_revisions WHERE cipher=$1", id)
+		if err != nil {
+			return err
+		}
+	}
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Important to check that the owner is correct before an update!
+func (db *DB) trashCiphers(owner string, ciphIDs []string) error {
+	now := time.Now()
+	return db.updateCiphers(owner, ciphIDs, "UPDATE ciphers SET deleteddate=$1, revisiondate=$2 WHERE id=$3 AND owner=$4", now.Unix(), unixMilli(now))
+}
+
+// Important to check that the owner is correct before an update!
+func (db *DB) restoreCiphers(owner string, ciphIDs []string) error {
+	return db.updateCiphers(owner, ciphIDs, "UPDATE ciphers SET deleteddate=NULL, revisiondate=$1 WHERE id=$2 AND owner=$3", unixMilli(time.Now()))
+}
+
+// Important to check that the owner is correct before an update! Returns
+// errFolderNotFound if the folder doesn't belong to the owner.
+func (db *DB) moveCiphers(owner string, ciphIDs []string, folderID *string) error {
+	ids, err := legacyCipherIDs(db.db, ciphIDs)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkFolder(tx, folderID, owner)
+	if err != nil {
+		return err
+	}
+
+	err = updateOwnedCiphers(tx, "UPDATE ciphers SET folderid=$1, revisiondate=$2 WHERE id=$3 AND owner=$4", ids, owner, folderID, unixMilli(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updateCiphers runs updateOwnedCiphers in a new transaction
+func (db *DB) updateCiphers(owner string, ciphIDs []string, query string, args ...interface{}) error {
+	ids, err := legacyCipherIDs(db.db, ciphIDs)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateOwnedCiphers(tx, query, ids, owner, args...)
+	if err != nil {
+		return err
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// purgeCiphers permanently deletes the ciphers of all accounts that were
+// moved to the trash before the given time. The attachments of the deleted
+// ciphers are returned so their blobs can be removed.
+func (db *DB) purgeCiphers(deletedBefore time.Time) ([]Attachment, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT a.id, a.cipher FROM attachments a JOIN ciphers c ON a.cipher = c.id WHERE c.deleteddate < $1", deletedBefore.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []Attachment
+	for rows.Next() {
+		a := Attachment{}
+		err := rows.Scan(&a.Id, &a.CipherId)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	// The owners have to sync to see the ciphers disappear
+	_, err = tx.Exec("UPDATE accounts SET revisiondate=$1 WHERE id IN (SELECT owner FROM ciphers WHERE deleteddate < $2)", unixMilli(time.Now()), deletedBefore.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	queries := []string{
+		"DELETE FROM attachments WHERE cipher IN (SELECT id FROM ciphers WHERE deleteddate < $1)",
+		"DELETE FROM favorites WHERE cipher IN (SELECT id FROM ciphers WHERE deleteddate < $1)",
+		"DELETE FROM cipher_revisions WHERE cipher IN (SELECT id FROM ciphers WHERE deleteddate < $1)",
+		"DELETE FROM ciphers WHERE deleteddate < $1",
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(query, deletedBefore.Unix())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return attachments, tx.Commit()
+}
+
+func (db *DB) addAccount(acc Account) error {
+	stmt, err := db.db.Prepare("INSERT INTO accounts(id, name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken, privatekey, securitystamp, revisiondate) values(?,?,?,?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(uuid.NewV4().String(), acc.Name, acc.Email, acc.MasterPasswordHash, acc.MasterPasswordHint, acc.Key, "", acc.PrivateKey, uuid.NewV4().String(), unixMilli(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) updateAccountInfo(id string, refreshToken string) error {
+	stmt, err := db.db.Prepare("UPDATE accounts SET refreshtoken=$1 WHERE id=$2")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(refreshToken, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+const accountColumns = "id, name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken, privatekey, securitystamp, quotaitems, quotadatabytes, quotaattachmentbytes, revisiondate, equivalentdomains, excludedglobaldomains"
+
+func (db *DB) getAccount(username string, refreshtoken string) (Account, error) {
+	var row *sql.Row
+	if username != "" {
+		query := "SELECT " + accountColumns + " FROM accounts WHERE email = $1"
+		row = db.db.QueryRow(query, username)
+	}
+	if refreshtoken != "" {
+		query := "SELECT " + accountColumns + " FROM accounts WHERE refreshtoken = $1"
+		row = db.db.QueryRow(query, refreshtoken)
+	}
+
+	return scanAccount(row)
+}
+
+// Either a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAccount reads a row with the accountColumns
+func scanAccount(row scanner) (Account, error) {
+	acc := Account{}
+	var revDate int64
+	var equivalent, excluded []byte
+	err := row.Scan(&acc.Id, &acc.Name, &acc.Email, &acc.MasterPasswordHash, &acc.MasterPasswordHint, &acc.Key, &acc.RefreshToken, &acc.PrivateKey, &acc.SecurityStamp, &acc.Quota.Items, &acc.Quota.DataBytes, &acc.Quota.AttachmentBytes, &revDate, &equivalent, &excluded)
+	if err != nil {
+		return acc, err
+	}
+	acc.RevisionDate = time.Unix(0, revDate*int64(time.Millisecond))
+
+	err = json.Unmarshal(equivalent, &acc.Domains.EquivalentDomains)
+	if err != nil {
+		return acc, err
+	}
+
+	err = json.Unmarshal(excluded, &acc.Domains.ExcludedGlobalEquivalentDomains)
+	return acc, err
+}
+
+func (db *DB) getAccounts() ([]Account, error) {
+	rows, err := db.db.Query("SELECT " + accountColumns + " FROM accounts ORDER BY email")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		acc, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acc)
+	}
+
+	return accounts, rows.Err()
+}
+
+// restoreAccounts adds the accounts from a backup with their folders,
+// ciphers and attachment records in one transaction. The folders and
+// attachments keep their ids, the ciphers get new ones. putBlobs is called
+// with a map from the old to the new cipher ids before the transaction is
+// committed, so nothing is restored if it fails.
+func (db *DB) restoreAccounts(accounts []backupAccount, putBlobs func(ids map[string]string) error) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := make(map[string]string)
+	for _, ba := range accounts {
+		err = restoreAccountTx(tx, ba.Account, ba.Folders, ba.Ciphers, ids)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = putBlobs(ids)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// restoreAccountTx adds one account of a backup and adds the new ids of its
+// ciphers to ids
+func restoreAccountTx(tx *sql.Tx, acc Account, folders []Folder, ciphs []Cipher, ids map[string]string) error {
+	equivalent, excluded, err := domainColumns(acc.Domains)
+	if err != nil {
+		return err
+	}
+
+	owner := uuid.NewV4().String()
+	_, err = tx.Exec("INSERT INTO accounts(id, name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken, privatekey, securitystamp, quotaitems, quotadatabytes, quotaattachmentbytes, revisiondate, equivalentdomains, excludedglobaldomains) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", owner, acc.Name, acc.Email, acc.MasterPasswordHash, acc.MasterPasswordHint, acc.Key, "", acc.PrivateKey, uuid.NewV4().String(), acc.Quota.Items, acc.Quota.DataBytes, acc.Quota.AttachmentBytes, unixMilli(time.Now()), equivalent, excluded)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range folders {
+		_, err = tx.Exec("INSERT INTO folders(id, name, revisiondate, owner) values(?,?,?,?)", f.Id, f.Name, f.RevisionDate.Unix(), owner)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ciph := range ciphs {
+		newCiph, err := newCipherTx(tx, ciph, owner)
+		if err != nil {
+			return err
+		}
+		ids[ciph.Id] = newCiph.Id
+
+		var deleted *int64
+		if ciph.DeletedDate != nil {
+			d := ciph.DeletedDate.Unix()
+			deleted = &d
+		}
+
+		// Keep the dates from the backup
+		_, err = tx.Exec("UPDATE ciphers SET revisiondate=$1, deleteddate=$2 WHERE id=$3", unixMilli(ciph.RevisionDate), deleted, newCiph.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range ciph.Attachments {
+			_, err = tx.Exec("INSERT INTO attachments(id, cipher, filename, key, size) values(?,?,?,?,?)", a.Id, newCiph.Id, a.FileName, a.Key, a.Size)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setDomainSettings changes the equivalent domains of the account
+func (db *DB) setDomainSettings(owner string, settings DomainSettings) error {
+	equivalent, excluded, err := domainColumns(settings)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE accounts SET equivalentdomains=$1, excludedglobaldomains=$2 WHERE id=$3", equivalent, excluded, owner)
+	if err != nil {
+		return err
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The domain settings are stored as json in two columns
+func domainColumns(settings DomainSettings) (string, string, error) {
+	if settings.EquivalentDomains == nil {
+		settings.EquivalentDomains = [][]string{}
+	}
+	if settings.ExcludedGlobalEquivalentDomains == nil {
+		settings.ExcludedGlobalEquivalentDomains = []int{}
+	}
+
+	equivalent, err := json.Marshal(settings.EquivalentDomains)
+	if err != nil {
+		return "", "", err
+	}
+
+	excluded, err := json.Marshal(settings.ExcludedGlobalEquivalentDomains)
+	return string(equivalent), string(excluded), err
+}
+
+// Returned when a change would make an account use more than its quota
+var errQuotaExceeded = errors.New("Not enough storage available.")
+
+func (db *DB) getUsage(owner string) (Usage, error) {
+	return usage(db.db, owner)
+}
+
+func usage(q queryer, owner string) (Usage, error) {
+	var u Usage
+	err := q.QueryRow("SELECT count(*), IFNULL(sum(length(data) + IFNULL(length(passwordhistory), 0)), 0) FROM ciphers WHERE owner=$1", owner).Scan(&u.Items, &u.DataBytes)
+	if err != nil {
+		return u, err
+	}
+
+	err = q.QueryRow("SELECT IFNULL(sum(a.size), 0) FROM attachments a JOIN ciphers c ON a.cipher = c.id WHERE c.owner=$1", owner).Scan(&u.AttachmentBytes)
+	return u, err
+}
+
+// checkQuota returns errQuotaExceeded if the account uses more than its
+// quota of something and more than it did before the change. Changes that
+// don't add anything are allowed after the quota was lowered.
+func checkQuota(tx *sql.Tx, owner string, before Usage) error {
+	var quota Quota
+	err := tx.QueryRow("SELECT quotaitems, quotadatabytes, quotaattachmentbytes FROM accounts WHERE id=$1", owner).Scan(&quota.Items, &quota.DataBytes, &quota.AttachmentBytes)
+	if err != nil {
+		return err
+	}
+
+	after, err := usage(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	limits := quota.limits()
+	over := func(used int64, prev int64, limit int64) bool {
+		return limit > 0 && used > limit && used > prev
+	}
+	if over(after.Items, before.Items, limits.Items) ||
+		over(after.DataBytes, before.DataBytes, limits.DataBytes) ||
+		over(after.AttachmentBytes, before.AttachmentBytes, limits.AttachmentBytes) {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// setQuota changes the limits of the account with the given email
+func (db *DB) setQuota(email string, quota Quota) error {
+	res, err := db.db.Exec("UPDATE accounts SET quotaitems=$1, quotadatabytes=$2, quotaattachmentbytes=$3 WHERE email=$4", quota.Items, quota.DataBytes, quota.AttachmentBytes, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("account " + email + " not found")
+	}
+	return nil
+}
+
+// Returned when a key rotation doesn't include exactly the account's ciphers and folders
+var errKeyRotationMismatch = errors.New("All existing ciphers and folders must be included in the rotation.")
+
+// rotateKey stores the account's new keys with the ciphers, attachments and
+// folders re-encrypted with them. Either everything is changed or nothing,
+// and it fails with errKeyRotationMismatch if anything is missing. The
+// security stamp is changed and the refresh token removed to log out the
+// other sessions. Ciphers shared with an organization don't use the key.
+func (db *DB) rotateKey(acc Account, ciphs []Cipher, folders []Folder) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Not with legacyCipherIDs, which drops duplicates
+	ciphIDs := make([]string, len(ciphs))
+	for i := range ciphs {
+		ciphIDs[i], err = legacyID(tx, "cipher", ciphs[i].Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkRotatedIDs(tx, ciphIDs, "SELECT id FROM ciphers WHERE owner=$1 AND IFNULL(organizationid, '') = ''", acc.Id)
+	if err != nil {
+		return err
+	}
+
+	folderIDs := make([]string, len(folders))
+	for i := range folders {
+		folderIDs[i] = folders[i].Id
+	}
+
+	err = checkRotatedIDs(tx, folderIDs, "SELECT id FROM folders WHERE owner=$1", acc.Id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, ciph := range ciphs {
+		var attIDs []string
+		for _, a := range ciph.Attachments {
+			attIDs = append(attIDs, a.Id)
+		}
+
+		err = checkRotatedIDs(tx, attIDs, "SELECT id FROM attachments WHERE cipher=$1", ciphIDs[i])
+		if err != nil {
+			return err
+		}
+
+		data, err := ciph.Data.bytes()
+		if err != nil {
+			return err
+		}
+
+		history, err := ciph.passwordHistoryBytes()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE ciphers SET data=$1, passwordhistory=$2, revisiondate=$3 WHERE id=$4 AND owner=$5", data, history, unixMilli(now), ciphIDs[i], acc.Id)
+		if err != nil {
+			return err
+		}
+
+		// The old versions can't be decrypted anymore
+		_, err = tx.Exec("DELETE FROM cipher_revisions WHERE cipher=$1", ciphIDs[i])
+		if err != nil {
+			return err
+		}
+
+		for _, a := range ciph.Attachments {
+			_, err = tx.Exec("UPDATE attachments SET filename=$1, key=$2 WHERE id=$3 AND cipher=$4", a.FileName, a.Key, a.Id, ciphIDs[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, f := range folders {
+		_, err = tx.Exec("UPDATE folders SET name=$1, revisiondate=$2 WHERE id=$3 AND owner=$4", f.Name, now.Unix(), f.Id, acc.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE accounts SET key=$1, privatekey=$2, securitystamp=$3, refreshtoken='' WHERE id=$4", acc.Key, acc.PrivateKey, uuid.NewV4().String(), acc.Id)
+	if err != nil {
+		return err
+	}
+
+	err = touchAccount(tx, acc.Id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkRotatedIDs returns errKeyRotationMismatch unless ids are exactly the
+// ones returned by the query
+func checkRotatedIDs(tx *sql.Tx, ids []string, query string, args ...interface{}) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return err
+		}
+		existing[id] = true
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	if len(ids) != len(existing) {
+		return errKeyRotationMismatch
+	}
+	for _, id := range ids {
+		if !existing[id] {
+			return errKeyRotationMismatch
+		}
+		delete(existing, id) // Catches duplicates
+	}
+	return nil
+}
+
+func (db *DB) addFolder(name string, owner string) (Folder, error) {
+	folder := Folder{
+		Id:           uuid.NewV4().String(),
+		Name:         name,
+		Object:       "folder",
+		RevisionDate: time.Now(),
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return Folder{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO folders(id, name, revisiondate, owner) values(?,?,?, ?)", folder.Id, folder.Name, folder.RevisionDate.Unix(), owner)
+	if err != nil {
+		return Folder{}, err
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return Folder{}, err
+	}
+
+	return folder, tx.Commit()
+}
+
+// Important to check that the owner is correct before an update!
+func (db *DB) updateFolder(owner string, folderID string, name string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE folders SET name=$1, revisiondate=$2 WHERE id=$3 AND owner=$4", name, time.Now().Unix(), folderID, owner)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteFolder removes the folder and takes the ciphers in it out of the
+// folder in the same transaction.
+// Important to check that the owner is correct before deleting!
+func (db *DB) deleteFolder(owner string, folderID string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM folders WHERE id=$1 AND owner=$2", folderID, owner)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec("UPDATE ciphers SET folderid=NULL, revisiondate=$1 WHERE folderid=$2 AND owner=$3", unixMilli(time.Now()), folderID, owner)
+	if err != nil {
+		return err
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) getFolders(owner string) ([]Folder, error) {
+	return db.queryFolders(owner, "")
+}
+
+func (db *DB) getFolder(owner string, folderID string) (Folder, error) {
+	folders, err := db.queryFolders(owner, folderID)
+	if err != nil {
+		return Folder{}, err
+	}
+
+	if len(folders) != 1 {
+		return Folder{}, sql.ErrNoRows
+	}
+	return folders[0], nil
+}
+
+// queryFolders returns the owners folders, or only the one with folderID if
+// it's not empty
+func (db *DB) queryFolders(owner string, folderID string) ([]Folder, error) {
+	var folders []Folder
+	query := "SELECT id, name, revisiondate FROM folders WHERE owner = $1"
+	args := []interface{}{owner}
+	if folderID != "" {
+		query += " AND id = $2"
+		args = append(args, folderID)
+	}
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revDate int64
+	for rows.Next() {
+		f := Folder{Object: "folder"}
+		err := rows.Scan(&f.Id, &f.Name, &revDate)
+		if err != nil {
+			return nil, err
+		}
+		f.RevisionDate = time.Unix(revDate, 0)
+
+		folders = append(folders, f)
+	}
+
+	if len(folders) < 1 {
+		folders = make([]Folder, 0) // Make an empty slice if there are none or android app will crash
+	}
+	return folders, rows.Err()
+}
+
+// Important to check that the owner is correct before adding!
+func (db *DB) addAttachment(att Attachment, owner string) error {
+	ciphID, err := legacyID(db.db, "cipher", att.CipherId)
+	if err != nil {
+		return err
+	}
+
+	size, err := strconv.ParseInt(att.Size, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := usage(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	// Only insert if the cipher belongs to the owner
+	res, err := tx.Exec("INSERT INTO attachments(id, cipher, filename, key, size) SELECT $1, id, $2, $3, $4 FROM ciphers WHERE id=$5 AND owner=$6", att.Id, att.FileName, att.Key, size, ciphID, owner)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("cipher " + att.CipherId + " not found")
+	}
+
+	err = checkQuota(tx, owner, before)
+	if err != nil {
+		return err
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getAttachments returns the attachments of a cipher, or of all the owners
+// ciphers if ciphID is empty
+func (db *DB) getAttachments(owner string, ciphID string) ([]Attachment, error) {
+	ciphID, err := legacyID(db.db, "cipher", ciphID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT a.id, a.cipher, a.filename, a.key, a.size FROM attachments a JOIN ciphers c ON a.cipher = c.id WHERE c.owner = $1"
+	args := []interface{}{owner}
+	if ciphID != "" {
+		query += " AND c.id = $2"
+		args = append(args, ciphID)
+	}
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	var size int64
+	for rows.Next() {
+		a := Attachment{Object: "attachment"}
+		err := rows.Scan(&a.Id, &a.CipherId, &a.FileName, &a.Key, &size)
+		if err != nil {
+			return nil, err
+		}
+		a.Size = strconv.FormatInt(size, 10)
+		a.SizeName = sizeName(size)
+
+		attachments = append(attachments, a)
+	}
+
+	return attachments, rows.Err()
+}
+
+func (db *DB) getAttachment(owner string, ciphID string, attID string) (Attachment, error) {
+	attachments, err := db.getAttachments(owner, ciphID)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	for _, a := range attachments {
+		if a.Id == attID {
+			return a, nil
+		}
+	}
+
+	return Attachment{}, sql.ErrNoRows
+}
+
+// Important to check that the owner is correct before deleting!
+func (db *DB) deleteAttachment(owner string, ciphID string, attID string) error {
+	ciphID, err := legacyID(db.db, "cipher", ciphID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM attachments WHERE id=$1 AND cipher IN (SELECT id FROM ciphers WHERE id=$2 AND owner=$3)", attID, ciphID, owner)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = touchAccount(tx, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// A cipher that got a UUID when the database was migrated
+type legacyCipher struct {
+	Id          string
+	LegacyId    string
+	Attachments []Attachment
+}
+
+// getUnmovedCiphers returns the migrated ciphers that haven't been marked
+// with setBlobsMoved yet, with their attachments
+func (db *DB) getUnmovedCiphers() ([]legacyCipher, error) {
+	rows, err := db.db.Query("SELECT l.newid, l.oldid, a.id, a.size FROM legacy_ids l LEFT JOIN attachments a ON a.cipher = l.newid WHERE l.kind = 'cipher' AND l.blobsmoved = 0 ORDER BY l.newid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ciphs []legacyCipher
+	for rows.Next() {
+		var c legacyCipher
+		var attID sql.NullString
+		var size sql.NullInt64
+		err := rows.Scan(&c.Id, &c.LegacyId, &attID, &size)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ciphs) == 0 || ciphs[len(ciphs)-1].Id != c.Id {
+			ciphs = append(ciphs, c)
+		}
+		if attID.Valid {
+			last := &ciphs[len(ciphs)-1]
+			last.Attachments = append(last.Attachments, Attachment{Id: attID.String, CipherId: c.Id, Size: strconv.FormatInt(size.Int64, 10)})
+		}
+	}
+
+	return ciphs, rows.Err()
+}
+
+// setBlobsMoved marks that the attachment blobs of a migrated cipher are
+// stored under its UUID
+func (db *DB) setBlobsMoved(ciphID string) error {
+	_, err := db.db.Exec("UPDATE legacy_ids SET blobsmoved=1 WHERE kind='cipher' AND newid=$1", ciphID)
+	return err
 }
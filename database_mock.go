@@ -1,9 +1,14 @@
 package main
 
-import _ "github.com/mattn/go-sqlite3"
+import (
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
 
 // mock database used for testing
 type mockDB struct {
+	id           string
 	username     string
 	password     string
 	refreshToken string
@@ -13,6 +18,10 @@ func (db *mockDB) init() error {
 	return nil
 }
 
+func (db *mockDB) migrate() error {
+	return nil
+}
+
 func (db *mockDB) open() error {
 	return nil
 }
@@ -24,20 +33,72 @@ func (db *mockDB) updateAccountInfo(sid string, refreshToken string) error {
 	return nil
 }
 
+func (db *mockDB) rotateKey(acc Account, ciphs []Cipher, folders []Folder) error {
+	return nil
+}
+
+func (db *mockDB) getUsage(owner string) (Usage, error) {
+	return Usage{}, nil
+}
+
+func (db *mockDB) setQuota(email string, quota Quota) error {
+	return nil
+}
+
+func (db *mockDB) setDomainSettings(owner string, settings DomainSettings) error {
+	return nil
+}
+
 func (db *mockDB) getCiphers(owner string) ([]Cipher, error) {
 	return nil, nil
 }
 
+func (db *mockDB) getCipher(owner string, ciphID string) (Cipher, error) {
+	return Cipher{}, nil
+}
+
 func (db *mockDB) newCipher(ciph Cipher, owner string) (Cipher, error) {
 	return Cipher{}, nil
 
 }
 
+func (db *mockDB) importVault(folders []Folder, ciphs []Cipher, folderOf map[int]int, owner string) error {
+	return nil
+}
+
 func (db *mockDB) updateCipher(newData Cipher, owner string, ciphID string) error {
 	return nil
 }
 
-func (db *mockDB) deleteCipher(owner string, ciphID string) error {
+func (db *mockDB) updateCipherPartial(owner string, ciphID string, folderID *string, favorite bool) error {
+	return nil
+}
+
+func (db *mockDB) deleteCiphers(owner string, ciphIDs []string) error {
+	return nil
+}
+
+func (db *mockDB) moveCiphers(owner string, ciphIDs []string, folderID *string) error {
+	return nil
+}
+
+func (db *mockDB) trashCiphers(owner string, ciphIDs []string) error {
+	return nil
+}
+
+func (db *mockDB) restoreCiphers(owner string, ciphIDs []string) error {
+	return nil
+}
+
+func (db *mockDB) purgeCiphers(deletedBefore time.Time) ([]Attachment, error) {
+	return nil, nil
+}
+
+func (db *mockDB) getCipherRevisions(owner string, ciphID string) ([]CipherRevision, error) {
+	return nil, nil
+}
+
+func (db *mockDB) restoreCipherRevision(owner string, ciphID string, revID string, device string) error {
 	return nil
 }
 
@@ -46,7 +107,15 @@ func (db *mockDB) addAccount(acc Account) error {
 }
 
 func (db *mockDB) getAccount(username string, refreshtoken string) (Account, error) {
-	return Account{Email: db.username, MasterPasswordHash: db.password, RefreshToken: db.refreshToken}, nil
+	return Account{Id: db.id, Email: db.username, MasterPasswordHash: db.password, RefreshToken: db.refreshToken}, nil
+}
+
+func (db *mockDB) getAccounts() ([]Account, error) {
+	return []Account{Account{Email: db.username, MasterPasswordHash: db.password, RefreshToken: db.refreshToken}}, nil
+}
+
+func (db *mockDB) restoreAccounts(accounts []backupAccount, putBlobs func(ids map[string]string) error) error {
+	return nil
 }
 
 func (db *mockDB) addFolder(name string, owner string) (Folder, error) {
@@ -56,3 +125,39 @@ func (db *mockDB) addFolder(name string, owner string) (Folder, error) {
 func (db *mockDB) getFolders(owner string) ([]Folder, error) {
 	return nil, nil
 }
+
+func (db *mockDB) addAttachment(att Attachment, owner string) error {
+	return nil
+}
+
+func (db *mockDB) getAttachments(owner string, ciphID string) ([]Attachment, error) {
+	return nil, nil
+}
+
+func (db *mockDB) getAttachment(owner string, ciphID string, attID string) (Attachment, error) {
+	return Attachment{}, nil
+}
+
+func (db *mockDB) deleteAttachment(owner string, ciphID string, attID string) error {
+	return nil
+}
+
+func (db *mockDB) getFolder(owner string, folderID string) (Folder, error) {
+	return Folder{}, nil
+}
+
+func (db *mockDB) getUnmovedCiphers() ([]legacyCipher, error) {
+	return nil, nil
+}
+
+func (db *mockDB) setBlobsMoved(ciphID string) error {
+	return nil
+}
+
+func (db *mockDB) updateFolder(owner string, folderID string, name string) error {
+	return nil
+}
+
+func (db *mockDB) deleteFolder(owner string, folderID string) error {
+	return nil
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// openTestDB opens an empty database in a temporary directory. The returned
+// function closes and removes it again.
+func openTestDB(t *testing.T) (*DB, func()) {
+	dir, err := ioutil.TempDir("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &DB{}
+	err = d.openFile(filepath.Join(dir, "db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return d, func() {
+		d.close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestDB opens a new database like openTestDB and creates the tables
+func newTestDB(t *testing.T) (*DB, func()) {
+	d, cleanup := openTestDB(t)
+	err := d.init()
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return d, cleanup
+}
+
+// useTestDB makes a database from newTestDB the one used by the handlers
+func useTestDB(t *testing.T) (*DB, func()) {
+	d, cleanup := newTestDB(t)
+	old := db
+	db = d
+	return d, func() {
+		db = old
+		cleanup()
+	}
+}
+
+// newTestAccount adds an account with the email and returns it as stored
+func newTestAccount(t *testing.T, d *DB, email string) Account {
+	err := d.addAccount(Account{Name: "nobody", Email: email, Key: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acc, err := d.getAccount(email, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acc
+}
+
+// findCipher returns the cipher with the id from the list, or an empty one
+func findCipher(ciphs []Cipher, id string) Cipher {
+	for _, ciph := range ciphs {
+		if ciph.Id == id {
+			return ciph
+		}
+	}
+	return Cipher{}
+}
+
+// The tables of the first version, with integer ids for accounts and ciphers
+var baselineSchema = []string{
+	"CREATE TABLE \"accounts\" ( `id` INTEGER, `name` TEXT, `email` TEXT UNIQUE, `masterPasswordHash` NUMERIC, `masterPasswordHint` TEXT, `key` TEXT, 'refreshtoken' TEXT, PRIMARY KEY(id) );",
+	"CREATE TABLE \"ciphers\" ( `id` INTEGER PRIMARY KEY AUTOINCREMENT, `type` INTEGER, `revisiondate` INTEGER, `data` BLOB, `owner` INTEGER );",
+	"CREATE TABLE \"folders\" (`id`	TEXT,	`name`	TEXT,	`revisiondate`	INTEGER,	`owner`	INTEGER, PRIMARY KEY(id))",
+	"INSERT INTO accounts(name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken) values('nobody', 'nobody@example.com', 'base64password', '', 'key', 'abcdef')",
+	"INSERT INTO folders(id, name, revisiondate, owner) values('folder', 'name', 1500000000, 1)",
+	"INSERT INTO ciphers(type, revisiondate, data, owner) values(1, 1500000000, '{}', 1)",
+}
+
+// openBaselineDB opens a database like openTestDB with the tables of the
+// first version and runs the extra queries on it before migrating it
+func openBaselineDB(t *testing.T, queries ...string) (*DB, func()) {
+	d, cleanup := openTestDB(t)
+
+	for _, query := range append(baselineSchema, queries...) {
+		_, err := d.db.Exec(query)
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+
+	err := d.migrate()
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return d, cleanup
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	d, cleanup := openBaselineDB(t)
+	defer cleanup()
+
+	acc, err := d.getAccount("nobody@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acc.Id) != 36 || acc.Key != "key" {
+		t.Errorf("Expected the account with a UUID got %+v", acc)
+	}
+	if unixMilli(acc.RevisionDate) == 0 {
+		t.Errorf("Expected the account with a revision date got %v", acc.RevisionDate)
+	}
+
+	// Still found with the old id
+	old, err := d.getCipher(acc.Id, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old.Id) != 36 || !old.RevisionDate.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("Expected the cipher with a UUID from %v got %v from %v", time.Unix(1500000000, 0), old.Id, old.RevisionDate)
+	}
+
+	folder := "folder"
+	ciph, err := d.newCipher(Cipher{Type: 1, FolderId: &folder, Favorite: true}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphs, err := d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 2 || findCipher(ciphs, old.Id).FolderId != nil || findCipher(ciphs, ciph.Id).FolderId == nil || !findCipher(ciphs, ciph.Id).Favorite {
+		t.Errorf("Expected the old cipher and one in the folder got %+v", ciphs)
+	}
+
+	_, err = d.getAttachments(acc.Id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing left to do
+	err = d.migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciph, err = d.getCipher(acc.Id, "1")
+	if err != nil || ciph.Id != old.Id {
+		t.Errorf("Expected the same UUID after migrating again got %v %v", ciph.Id, err)
+	}
+}
+
+func TestCipherFolderOwner(t *testing.T) {
+	d, cleanup := newTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	other := newTestAccount(t, d, "other@example.com")
+
+	folder, err := d.addFolder("name", acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.newCipher(Cipher{Type: 1, FolderId: &folder.Id}, other.Id)
+	if err != errFolderNotFound {
+		t.Errorf("Expected %v for a folder of another account got %v", errFolderNotFound, err)
+	}
+
+	ciph, err := d.newCipher(Cipher{Type: 1}, other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.updateCipher(Cipher{Type: 1, FolderId: &folder.Id}, other.Id, ciph.Id)
+	if err != errFolderNotFound {
+		t.Errorf("Expected %v for a folder of another account got %v", errFolderNotFound, err)
+	}
+
+	_, err = d.newCipher(Cipher{Type: 1, FolderId: &folder.Id}, acc.Id)
+	if err != nil {
+		t.Errorf("Expected the cipher in the own folder got %v", err)
+	}
+}
+
+func TestTrashCiphers(t *testing.T) {
+	d, cleanup := newTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	other := newTestAccount(t, d, "other@example.com")
+
+	trashed, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.addAttachment(Attachment{Id: "attachment", CipherId: trashed.Id, Size: "1"}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.trashCiphers(other.Id, []string{trashed.Id})
+	if err == nil {
+		t.Error("Expected an error for a cipher of another account")
+	}
+
+	err = d.trashCiphers(acc.Id, []string{trashed.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Trashed ciphers are still synced, with their deleted date
+	ciphs, err := d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 2 || findCipher(ciphs, trashed.Id).DeletedDate == nil || findCipher(ciphs, kept.Id).DeletedDate != nil {
+		t.Fatalf("Expected one of two ciphers in the trash got %+v", ciphs)
+	}
+
+	err = d.restoreCiphers(acc.Id, []string{trashed.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphs, err = d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 2 || findCipher(ciphs, trashed.Id).DeletedDate != nil {
+		t.Fatalf("Expected the cipher to be restored got %+v", ciphs)
+	}
+
+	// Only ciphers in the trash are purged
+	err = d.trashCiphers(acc.Id, []string{trashed.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := d.purgeCiphers(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || attachments[0].Id != "attachment" || attachments[0].CipherId != trashed.Id {
+		t.Errorf("Expected the attachment of the purged cipher got %+v", attachments)
+	}
+
+	ciphs, err = d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 1 || ciphs[0].Id != kept.Id || len(ciphs[0].Attachments) != 0 {
+		t.Errorf("Expected only cipher %v to be left got %+v", kept.Id, ciphs)
+	}
+}
+
+func TestCipherRevisions(t *testing.T) {
+	d, cleanup := newTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	other := newTestAccount(t, d, "other@example.com")
+
+	ciph, err := d.newCipher(Cipher{Type: 1, Data: CipherData{Name: "0"}}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= maxCipherRevisions+2; i++ {
+		err = d.updateCipher(Cipher{Type: 1, Data: CipherData{Name: strconv.Itoa(i)}, Device: "device"}, acc.Id, ciph.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the newest revisions are kept
+	revisions, err := d.getCipherRevisions(acc.Id, ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != maxCipherRevisions {
+		t.Fatalf("Expected %v revisions got %v", maxCipherRevisions, len(revisions))
+	}
+	last := strconv.Itoa(maxCipherRevisions + 1)
+	if revisions[0].Data.Name != last || revisions[0].Device != "device" || revisions[len(revisions)-1].Data.Name != "2" {
+		t.Errorf("Expected the revisions from %v down to 2 got %+v", last, revisions)
+	}
+
+	// Other accounts can't see or add revisions
+	newest := revisions[0].Id
+	err = d.updateCipher(Cipher{Type: 1, Data: CipherData{Name: "other"}}, other.Id, ciph.Id)
+	if err == nil {
+		t.Error("Expected an error updating a cipher of another account")
+	}
+	revisions, err = d.getCipherRevisions(other.Id, ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 0 {
+		t.Errorf("Expected no revisions for another account got %v", len(revisions))
+	}
+	err = d.restoreCipherRevision(other.Id, ciph.Id, newest, "device")
+	if err == nil {
+		t.Error("Expected an error restoring a cipher of another account")
+	}
+
+	// Restoring keeps the replaced version as a revision
+	err = d.restoreCipherRevision(acc.Id, ciph.Id, newest, "device")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphs, err := d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 1 || ciphs[0].Data.Name != last {
+		t.Errorf("Expected the restored cipher got %+v", ciphs)
+	}
+
+	revisions, err = d.getCipherRevisions(acc.Id, ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != maxCipherRevisions || revisions[0].Data.Name != strconv.Itoa(maxCipherRevisions+2) {
+		t.Errorf("Expected the replaced version as the newest revision got %+v", revisions[0])
+	}
+}
+
+func TestCipherConflict(t *testing.T) {
+	d, cleanup := newTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+
+	ciph, err := d.newCipher(Cipher{Type: 1, Data: CipherData{Name: "0"}}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := d.getCipher(acc.Id, ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Based on the stored version
+	known := stored.RevisionDate
+	err = d.updateCipher(Cipher{Type: 1, Data: CipherData{Name: "1"}, LastKnownRevisionDate: &known}, acc.Id, ciph.Id)
+	if err != nil {
+		t.Fatalf("Expected the update to be accepted got %v", err)
+	}
+
+	// Based on the version before that one
+	err = d.updateCipher(Cipher{Type: 1, Data: CipherData{Name: "2"}, LastKnownRevisionDate: &known}, acc.Id, ciph.Id)
+	if err != errCipherConflict {
+		t.Errorf("Expected %v for an outdated revision got %v", errCipherConflict, err)
+	}
+	stored, err = d.getCipher(acc.Id, ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Data.Name != "1" {
+		t.Errorf("Expected the outdated update to be rejected got %v", stored.Data.Name)
+	}
+
+	// Older clients don't send the date
+	err = d.updateCipher(Cipher{Type: 1, Data: CipherData{Name: "3"}}, acc.Id, ciph.Id)
+	if err != nil {
+		t.Errorf("Expected an update without a date to be accepted got %v", err)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	d, cleanup := openBaselineDB(t)
+	defer cleanup()
+
+	acc, err := d.getAccount("nobody@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciph, err := d.newCipher(Cipher{Type: 1, Data: CipherData{Name: "old"}}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.addAttachment(Attachment{Id: "attachment", CipherId: ciph.Id, FileName: "old", Key: "old", Size: "1"}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := func(ids ...string) []Cipher {
+		var ciphs []Cipher
+		for _, id := range ids {
+			c := Cipher{Id: id, Type: 1, Data: CipherData{Name: "new"}}
+			if id == ciph.Id {
+				c.Attachments = []Attachment{{Id: "attachment", FileName: "new", Key: "new"}}
+			}
+			ciphs = append(ciphs, c)
+		}
+		return ciphs
+	}
+	folders := []Folder{{Id: "folder", Name: "new"}}
+	acc.Key = "new key"
+
+	// The migrated cipher is still sent with its old id by clients that haven't synced
+	cases := map[string][]Cipher{
+		"a missing cipher":     rotated("1"),
+		"an unknown cipher":    rotated("1", ciph.Id, "unknown"),
+		"a duplicate cipher":   rotated("1", "1"),
+		"a missing attachment": append(rotated("1"), Cipher{Id: ciph.Id, Type: 1}),
+	}
+	for name, ciphs := range cases {
+		err = d.rotateKey(acc, ciphs, folders)
+		if err != errKeyRotationMismatch {
+			t.Errorf("Expected %v for %s got %v", errKeyRotationMismatch, name, err)
+		}
+	}
+	err = d.rotateKey(acc, rotated("1", ciph.Id), nil)
+	if err != errKeyRotationMismatch {
+		t.Errorf("Expected %v without the folder got %v", errKeyRotationMismatch, err)
+	}
+
+	unchanged, err := d.getAccount(acc.Email, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.Key != "key" || unchanged.SecurityStamp != acc.SecurityStamp {
+		t.Fatalf("Expected nothing to change got %+v", unchanged)
+	}
+
+	err = d.rotateKey(acc, rotated("1", ciph.Id), folders)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := d.getAccount(acc.Email, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Key != "new key" || changed.SecurityStamp == acc.SecurityStamp || changed.RefreshToken != "" {
+		t.Errorf("Expected the new key and a new security stamp got %+v", changed)
+	}
+
+	ciphs, err := d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range ciphs {
+		if c.Data.Name != "new" {
+			t.Errorf("Expected cipher %v to be re-encrypted got %v", c.Id, c.Data.Name)
+		}
+	}
+	att, err := d.getAttachment(acc.Id, ciph.Id, "attachment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.FileName != "new" || att.Key != "new" {
+		t.Errorf("Expected the re-encrypted attachment got %+v", att)
+	}
+	f, err := d.getFolder(acc.Id, "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "new" {
+		t.Errorf("Expected the re-encrypted folder got %v", f.Name)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	d, cleanup := newTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	items := int64(5)
+	err := d.setQuota(acc.Email, Quota{Items: &items})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every write reads the usage first, so they'd fail with SQLITE_BUSY
+	// if the write lock was only taken when they write
+	errs := make(chan error)
+	for i := 0; i < 20; i++ {
+		go func() {
+			_, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+			errs <- err
+		}()
+	}
+
+	created := 0
+	for i := 0; i < 20; i++ {
+		err := <-errs
+		if err == nil {
+			created++
+		} else if err != errQuotaExceeded {
+			t.Error(err)
+		}
+	}
+	if created != int(items) {
+		t.Errorf("Expected %v ciphers got %v", items, created)
+	}
+}
+
+func TestAccountRevisionDate(t *testing.T) {
+	d, cleanup := newTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	folder, err := d.addFolder("folder", acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciph, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := []struct {
+		name  string
+		write func() error
+	}{
+		// First, while the cipher and the folder are all there is
+		{"rotateKey", func() error {
+			return d.rotateKey(acc, []Cipher{{Id: ciph.Id, Type: 1}}, []Folder{{Id: folder.Id, Name: "folder"}})
+		}},
+		{"newCipher", func() error { _, err := d.newCipher(Cipher{Type: 1}, acc.Id); return err }},
+		{"updateCipher", func() error { return d.updateCipher(Cipher{Type: 1}, acc.Id, ciph.Id) }},
+		{"updateCipherPartial", func() error { return d.updateCipherPartial(acc.Id, ciph.Id, nil, true) }},
+		{"restoreCipherRevision", func() error {
+			revisions, err := d.getCipherRevisions(acc.Id, ciph.Id)
+			if err != nil {
+				return err
+			}
+			return d.restoreCipherRevision(acc.Id, ciph.Id, revisions[0].Id, "")
+		}},
+		{"importVault", func() error { return d.importVault(nil, []Cipher{{Type: 1}}, nil, acc.Id) }},
+		{"moveCiphers", func() error { return d.moveCiphers(acc.Id, []string{ciph.Id}, &folder.Id) }},
+		{"trashCiphers", func() error { return d.trashCiphers(acc.Id, []string{ciph.Id}) }},
+		{"restoreCiphers", func() error { return d.restoreCiphers(acc.Id, []string{ciph.Id}) }},
+		{"addAttachment", func() error {
+			return d.addAttachment(Attachment{Id: "attachment", CipherId: ciph.Id, Size: "1"}, acc.Id)
+		}},
+		{"deleteAttachment", func() error { return d.deleteAttachment(acc.Id, ciph.Id, "attachment") }},
+		{"addFolder", func() error { _, err := d.addFolder("folder", acc.Id); return err }},
+		{"updateFolder", func() error { return d.updateFolder(acc.Id, folder.Id, "renamed") }},
+		{"deleteFolder", func() error { return d.deleteFolder(acc.Id, folder.Id) }},
+		{"deleteCiphers", func() error { return d.deleteCiphers(acc.Id, []string{ciph.Id}) }},
+		{"setDomainSettings", func() error { return d.setDomainSettings(acc.Id, DomainSettings{}) }},
+	}
+
+	for _, w := range writes {
+		_, err := d.db.Exec("UPDATE accounts SET revisiondate=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = w.write()
+		if err != nil {
+			t.Fatalf("%s: %v", w.name, err)
+		}
+
+		changed, err := d.getAccount(acc.Email, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unixMilli(changed.RevisionDate) <= 1 {
+			t.Errorf("Expected %s to change the revision date", w.name)
+		}
+	}
+}
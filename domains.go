@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Groups of domains that belong together for all users, like the country
+// sites of a company. Type identifies a group when users exclude it.
+var globalEquivalentDomains = defaultGlobalEquivalentDomains
+
+// loadGlobalDomains replaces the global equivalent domains with the ones in
+// a json file, in the format the official server uses:
+// [{"type": 0, "domains": ["youtube.com", "google.com"]}, ...]
+func loadGlobalDomains(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var groups []GlobalEquivalentDomains
+	err = json.Unmarshal(data, &groups)
+	if err != nil {
+		return err
+	}
+
+	types := make(map[int]bool)
+	for i, g := range groups {
+		if types[g.Type] {
+			return fmt.Errorf("%s: type %d is used twice", path, g.Type)
+		}
+		types[g.Type] = true
+
+		clean, err := cleanDomainSettings(DomainSettings{EquivalentDomains: [][]string{g.Domains}})
+		if err != nil {
+			return fmt.Errorf("%s: group %d: %s", path, g.Type, err.Error())
+		}
+		groups[i].Domains = clean.EquivalentDomains[0]
+		groups[i].Excluded = false
+	}
+
+	globalEquivalentDomains = groups
+	return nil
+}
+
+// accountDomains returns the equivalent domains of the account. The global
+// groups the user excluded are either left out or marked as excluded.
+func accountDomains(acc Account, withExcluded bool) Domains {
+	excluded := make(map[int]bool)
+	for _, t := range acc.Domains.ExcludedGlobalEquivalentDomains {
+		excluded[t] = true
+	}
+
+	domains := Domains{
+		EquivalentDomains:       acc.Domains.EquivalentDomains,
+		GlobalEquivalentDomains: make([]GlobalEquivalentDomains, 0, len(globalEquivalentDomains)),
+		Object:                  "domains",
+	}
+	if domains.EquivalentDomains == nil {
+		domains.EquivalentDomains = [][]string{}
+	}
+
+	for _, g := range globalEquivalentDomains {
+		if excluded[g.Type] && !withExcluded {
+			continue
+		}
+		g.Excluded = excluded[g.Type]
+		domains.GlobalEquivalentDomains = append(domains.GlobalEquivalentDomains, g)
+	}
+	return domains
+}
+
+// cleanDomainSettings lowercases the domains and removes duplicates. Every
+// group needs at least two domains.
+func cleanDomainSettings(settings DomainSettings) (DomainSettings, error) {
+	var clean DomainSettings
+	for i, group := range settings.EquivalentDomains {
+		seen := make(map[string]bool)
+		var domains []string
+		for _, d := range group {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if d == "" || seen[d] {
+				continue
+			}
+			if strings.ContainsAny(d, " \t/:") {
+				return clean, &validationError{fmt.Sprintf("EquivalentDomains[%d]", i), fmt.Errorf("%s is not a domain", d)}
+			}
+			seen[d] = true
+			domains = append(domains, d)
+		}
+
+		if len(domains) < 2 {
+			return clean, &validationError{fmt.Sprintf("EquivalentDomains[%d]", i), errors.New("A group needs at least two domains.")}
+		}
+		clean.EquivalentDomains = append(clean.EquivalentDomains, domains)
+	}
+
+	seen := make(map[int]bool)
+	for _, t := range settings.ExcludedGlobalEquivalentDomains {
+		if !seen[t] {
+			seen[t] = true
+			clean.ExcludedGlobalEquivalentDomains = append(clean.ExcludedGlobalEquivalentDomains, t)
+		}
+	}
+	return clean, nil
+}
+
+// This function handles /api/settings/domains
+func handleDomainSettings(w http.ResponseWriter, req *http.Request) {
+	email := req.Context().Value(ctxKey("email")).(string)
+
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		log.Fatal("Account lookup " + err.Error())
+	}
+
+	switch req.Method {
+	case "GET":
+
+	case "PUT", "POST":
+		log.Println(email + " is trying to change the equivalent domains")
+
+		var settings DomainSettings
+		err = json.NewDecoder(req.Body).Decode(&settings)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(400)))
+			log.Println(err)
+			return
+		}
+		defer req.Body.Close()
+
+		settings, err = cleanDomainSettings(settings)
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		err = db.setDomainSettings(acc.Id, settings)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(500)))
+			log.Println(err)
+			return
+		}
+		acc.Domains = settings
+		pushUser(req, pushSyncSettings, acc.Id)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	data, err := json.Marshal(accountDomains(acc, true))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCleanDomainSettings(t *testing.T) {
+	settings, err := cleanDomainSettings(DomainSettings{
+		EquivalentDomains:               [][]string{{" Example.com", "example.org", "example.com", ""}},
+		ExcludedGlobalEquivalentDomains: []int{1, 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(settings.EquivalentDomains, [][]string{{"example.com", "example.org"}}) || !reflect.DeepEqual(settings.ExcludedGlobalEquivalentDomains, []int{1}) {
+		t.Fatalf("Wrong settings %v", settings)
+	}
+
+	_, err = cleanDomainSettings(DomainSettings{EquivalentDomains: [][]string{{"example.com", "EXAMPLE.COM"}}})
+	verr, ok := err.(*validationError)
+	if !ok || verr.field != "EquivalentDomains[0]" {
+		t.Fatalf("Expected error for a group with one domain got %v", err)
+	}
+
+	_, err = cleanDomainSettings(DomainSettings{EquivalentDomains: [][]string{{"example.com", "https://example.org"}}})
+	if err == nil {
+		t.Fatal("Urls should be rejected")
+	}
+}
+
+func TestAccountDomains(t *testing.T) {
+	acc := Account{Domains: DomainSettings{ExcludedGlobalEquivalentDomains: []int{globalEquivalentDomains[0].Type}}}
+
+	domains := accountDomains(acc, false)
+	if domains.EquivalentDomains == nil || len(domains.GlobalEquivalentDomains) != len(globalEquivalentDomains)-1 {
+		t.Fatalf("Excluded group not removed %v", domains)
+	}
+
+	domains = accountDomains(acc, true)
+	if len(domains.GlobalEquivalentDomains) != len(globalEquivalentDomains) || !domains.GlobalEquivalentDomains[0].Excluded {
+		t.Fatalf("Excluded group not marked %v", domains)
+	}
+	if globalEquivalentDomains[0].Excluded {
+		t.Fatal("Global groups changed")
+	}
+}
+
+func TestDefaultGlobalEquivalentDomains(t *testing.T) {
+	types := make(map[int]bool)
+	for _, g := range defaultGlobalEquivalentDomains {
+		if types[g.Type] {
+			t.Errorf("Type %d is used twice", g.Type)
+		}
+		types[g.Type] = true
+
+		clean, err := cleanDomainSettings(DomainSettings{EquivalentDomains: [][]string{g.Domains}})
+		if err != nil || !reflect.DeepEqual(clean.EquivalentDomains[0], g.Domains) {
+			t.Errorf("Invalid domains in group %d: %v", g.Type, err)
+		}
+	}
+}
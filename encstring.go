@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Encryption types of the strings encrypted by the clients
+const (
+	AesCbc256_B64 = iota
+	AesCbc128_HmacSha256_B64
+	AesCbc256_HmacSha256_B64
+	Rsa2048_OaepSha256_B64
+	Rsa2048_OaepSha1_B64
+	Rsa2048_OaepSha256_HmacSha256_B64
+	Rsa2048_OaepSha1_HmacSha256_B64
+)
+
+// validateEncString checks that s looks like a string encrypted by the
+// clients: "<type>.<base64 parts separated by |>". It can't check that the
+// content is actually encrypted, only that the format and lengths are right.
+func validateEncString(s string) error {
+	encType := -1
+	parts := s
+	if i := strings.Index(s, "."); i >= 0 {
+		t, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return fmt.Errorf("invalid encryption type %q", s[:i])
+		}
+		encType = t
+		parts = s[i+1:]
+	}
+
+	pieces := strings.Split(parts, "|")
+
+	// Old strings without the type
+	if encType == -1 {
+		switch len(pieces) {
+		case 2:
+			encType = AesCbc256_B64
+		case 3:
+			encType = AesCbc128_HmacSha256_B64
+		default:
+			return fmt.Errorf("missing encryption type")
+		}
+	}
+
+	decoded := make([][]byte, len(pieces))
+	for i, p := range pieces {
+		b, err := base64.StdEncoding.DecodeString(p)
+		if err != nil || len(b) == 0 {
+			return fmt.Errorf("part %d is not valid base64", i+1)
+		}
+		decoded[i] = b
+	}
+
+	switch encType {
+	case AesCbc256_B64:
+		if len(decoded) != 2 {
+			return fmt.Errorf("expected iv|data for type %d", encType)
+		}
+		return validateAes(decoded[0], decoded[1], nil)
+
+	case AesCbc128_HmacSha256_B64, AesCbc256_HmacSha256_B64:
+		if len(decoded) != 3 {
+			return fmt.Errorf("expected iv|data|mac for type %d", encType)
+		}
+		return validateAes(decoded[0], decoded[1], decoded[2])
+
+	case Rsa2048_OaepSha256_B64, Rsa2048_OaepSha1_B64:
+		if len(decoded) != 1 {
+			return fmt.Errorf("expected data for type %d", encType)
+		}
+		return validateRsa(decoded[0], nil)
+
+	case Rsa2048_OaepSha256_HmacSha256_B64, Rsa2048_OaepSha1_HmacSha256_B64:
+		if len(decoded) != 2 {
+			return fmt.Errorf("expected data|mac for type %d", encType)
+		}
+		return validateRsa(decoded[0], decoded[1])
+	}
+
+	return fmt.Errorf("unknown encryption type %d", encType)
+}
+
+func validateAes(iv []byte, data []byte, mac []byte) error {
+	if len(iv) != 16 {
+		return fmt.Errorf("iv must be 16 bytes, got %d", len(iv))
+	}
+	if len(data)%16 != 0 {
+		return fmt.Errorf("data must be a multiple of 16 bytes, got %d", len(data))
+	}
+	if mac != nil && len(mac) != 32 {
+		return fmt.Errorf("mac must be 32 bytes, got %d", len(mac))
+	}
+	return nil
+}
+
+func validateRsa(data []byte, mac []byte) error {
+	if len(data) != 256 {
+		return fmt.Errorf("data must be 256 bytes, got %d", len(data))
+	}
+	if mac != nil && len(mac) != 32 {
+		return fmt.Errorf("mac must be 32 bytes, got %d", len(mac))
+	}
+	return nil
+}
+
+// encField is an encrypted field, optional fields may be empty
+type encField struct {
+	name     string
+	value    string
+	required bool
+}
+
+func validateEncFields(fields []encField) error {
+	for _, f := range fields {
+		if f.value == "" {
+			if f.required {
+				return &validationError{f.name, fmt.Errorf("the %s field is required", f.name)}
+			}
+			continue
+		}
+
+		err := validateEncString(f.value)
+		if err != nil {
+			return &validationError{f.name, err}
+		}
+	}
+	return nil
+}
+
+func optional(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// validateCipher checks all the encrypted fields of a cipher
+func validateCipher(ciph Cipher) error {
+	fields := []encField{
+		{"Name", ciph.Data.Name, true},
+		{"Notes", optional(ciph.Data.Notes), false},
+		{"Login.Uri", ciph.Data.Uri, false},
+		{"Login.Username", ciph.Data.Username, false},
+		{"Login.Password", ciph.Data.Password, false},
+		{"Login.Totp", optional(ciph.Data.Totp), false},
+	}
+
+	for i, u := range ciph.Data.Uris {
+		fields = append(fields, encField{fmt.Sprintf("Login.Uris[%d].Uri", i), u.Uri, false})
+	}
+
+	for i, h := range ciph.PasswordHistory {
+		fields = append(fields, encField{fmt.Sprintf("PasswordHistory[%d].Password", i), h.Password, true})
+	}
+
+	for i, f := range ciph.Data.Fido2Credentials {
+		prefix := fmt.Sprintf("Login.Fido2Credentials[%d].", i)
+		fields = append(fields,
+			encField{prefix + "CredentialId", f.CredentialId, true},
+			encField{prefix + "KeyType", f.KeyType, true},
+			encField{prefix + "KeyAlgorithm", f.KeyAlgorithm, true},
+			encField{prefix + "KeyCurve", f.KeyCurve, true},
+			encField{prefix + "KeyValue", f.KeyValue, true},
+			encField{prefix + "RpId", f.RpId, true},
+			encField{prefix + "RpName", optional(f.RpName), false},
+			encField{prefix + "UserHandle", optional(f.UserHandle), false},
+			encField{prefix + "UserName", optional(f.UserName), false},
+			encField{prefix + "UserDisplayName", optional(f.UserDisplayName), false},
+			encField{prefix + "Counter", f.Counter, true},
+			encField{prefix + "Discoverable", f.Discoverable, true})
+	}
+
+	if ciph.Type == CipherTypeSshKey && ciph.Data.SshKey == nil {
+		return &validationError{"SshKey", fmt.Errorf("the SshKey field is required")}
+	}
+	if ciph.Data.SshKey != nil {
+		fields = append(fields,
+			encField{"SshKey.PrivateKey", ciph.Data.SshKey.PrivateKey, true},
+			encField{"SshKey.PublicKey", ciph.Data.SshKey.PublicKey, true},
+			encField{"SshKey.KeyFingerprint", ciph.Data.SshKey.KeyFingerprint, true})
+	}
+
+	for _, a := range ciph.Attachments {
+		fields = append(fields,
+			encField{fmt.Sprintf("Attachments2[%s].FileName", a.Id), a.FileName, true},
+			encField{fmt.Sprintf("Attachments2[%s].Key", a.Id), a.Key, true})
+	}
+
+	return validateEncFields(fields)
+}
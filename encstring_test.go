@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateEncString(t *testing.T) {
+	rsa := base64.StdEncoding.EncodeToString(make([]byte, 256))
+	mac := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	cases := []struct {
+		data  string
+		valid bool
+	}{{"2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=", true},
+		{"d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=", true}, // Without type
+		{"0.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==", true},
+		{"4." + rsa, true},
+		{"6." + rsa + "|" + mac, true},
+		{"plaintext password", false},
+		{"2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==", false}, // Missing mac
+		{"2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|01Ath5UqFZHk7csk5DVtkQ==", false},                 // Short mac
+		{"2.01Ath5UqFZHk7csk5DVt|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=", false}, // Short iv
+		{"2.d7MttWzJTSSKx1qXjHUxlQ==|not base64!|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=", false},          // Invalid data
+		{"2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVt|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=", false}, // Data not a multiple of the block size
+		{"4." + mac, false},
+		{"9.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=", false}, // Unknown type
+	}
+
+	for _, c := range cases {
+		err := validateEncString(c.data)
+		if (err == nil) != c.valid {
+			t.Errorf("Expected valid=%v for %v got %v", c.valid, c.data, err)
+		}
+	}
+}
+
+func TestValidateCipher(t *testing.T) {
+	enc := "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io="
+
+	ciph := Cipher{Data: CipherData{Name: enc, Password: "hunter2"}}
+	err := validateCipher(ciph)
+	verr, ok := err.(*validationError)
+	if !ok || verr.field != "Login.Password" {
+		t.Fatalf("Expected error for the password got %v", err)
+	}
+
+	ciph.Data.Password = enc
+	ciph.Data.Uris = []CipherURI{CipherURI{Uri: enc}}
+	if err := validateCipher(ciph); err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	ciph.Attachments = []Attachment{Attachment{Id: "a", FileName: enc, Key: "key"}}
+	err = validateCipher(ciph)
+	verr, ok = err.(*validationError)
+	if !ok || verr.field != "Attachments2[a].Key" {
+		t.Fatalf("Expected error for the attachment key got %v", err)
+	}
+	ciph.Attachments = nil
+
+	ciph.Data.Fido2Credentials = []Fido2Credential{Fido2Credential{CredentialId: enc, KeyType: enc, KeyAlgorithm: enc, KeyCurve: enc, RpId: enc, Counter: enc, Discoverable: enc}}
+	err = validateCipher(ciph)
+	verr, ok = err.(*validationError)
+	if !ok || verr.field != "Login.Fido2Credentials[0].KeyValue" {
+		t.Fatalf("Expected error for the key value got %v", err)
+	}
+	ciph.Data.Fido2Credentials = nil
+
+	ciph.Data.SshKey = &SshKey{PrivateKey: "private", PublicKey: enc, KeyFingerprint: enc}
+	err = validateCipher(ciph)
+	verr, ok = err.(*validationError)
+	if !ok || verr.field != "SshKey.PrivateKey" {
+		t.Fatalf("Expected error for the unencrypted SSH key of a login got %v", err)
+	}
+	ciph.Data.SshKey = nil
+
+	ciph.Type = CipherTypeSshKey
+	err = validateCipher(ciph)
+	verr, ok = err.(*validationError)
+	if !ok || verr.field != "SshKey" || verr.err.Error() != "the SshKey field is required" {
+		t.Fatalf("Expected error for the missing SSH key got %v", err)
+	}
+	ciph.Data.SshKey = &SshKey{PrivateKey: enc, PublicKey: enc, KeyFingerprint: enc}
+	if err := validateCipher(ciph); err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	ciph.Data.Name = ""
+	if err := validateCipher(ciph); err == nil {
+		t.Fatal("Name should be required")
+	}
+}
+
+func TestWriteValidationError(t *testing.T) {
+	res := httptest.NewRecorder()
+	writeValidationError(res, &validationError{"Name", errors.New("invalid")})
+
+	if res.Code != 400 {
+		t.Fatalf("Expected 400 got %v", res.Code)
+	}
+
+	var errModel ErrorModel
+	err := json.Unmarshal(res.Body.Bytes(), &errModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errModel.Object != "error" || len(errModel.ValidationErrors["Name"]) != 1 {
+		t.Fatalf("Wrong error model %s", res.Body.String())
+	}
+}
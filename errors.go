@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// The error we send to the client when a request fails
+type ErrorModel struct {
+	Message          string
+	ValidationErrors map[string][]string
+	Object           string
+}
+
+type validationError struct {
+	field string
+	err   error
+}
+
+func (e *validationError) Error() string {
+	return e.field + ": " + e.err.Error()
+}
+
+// writeError sends a message to the client as an ErrorModel
+func writeError(w http.ResponseWriter, status int, message string) {
+	data, err := json.Marshal(&ErrorModel{Message: message, Object: "error"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Error " + message)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// writeValidationError sends err to the client as a 400 ErrorModel
+func writeValidationError(w http.ResponseWriter, err error) {
+	errModel := ErrorModel{
+		Message:          "The model state is invalid.",
+		ValidationErrors: map[string][]string{},
+		Object:           "error",
+	}
+
+	if verr, ok := err.(*validationError); ok {
+		errModel.ValidationErrors[verr.field] = []string{verr.err.Error()}
+	} else {
+		errModel.ValidationErrors[""] = []string{err.Error()}
+	}
+
+	data, err := json.Marshal(&errModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Validation error " + string(data))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(data)
+}
@@ -0,0 +1,97 @@
+package main
+
+// The global equivalent domains of the official server. Type is the same as
+// there, so the groups users excluded stay excluded when they switch servers.
+// Types that were retired upstream are not reused.
+var defaultGlobalEquivalentDomains = []GlobalEquivalentDomains{
+	GlobalEquivalentDomains{Type: 0, Domains: []string{"youtube.com", "google.com", "gmail.com"}},                                                    // Google
+	GlobalEquivalentDomains{Type: 1, Domains: []string{"apple.com", "icloud.com"}},                                                                   // Apple
+	GlobalEquivalentDomains{Type: 2, Domains: []string{"ameritrade.com", "tdameritrade.com"}},                                                        // Ameritrade
+	GlobalEquivalentDomains{Type: 3, Domains: []string{"bankofamerica.com", "bofa.com", "mbna.com", "usecfo.com"}},                                   // Bank of America
+	GlobalEquivalentDomains{Type: 4, Domains: []string{"sprint.com", "sprintpcs.com", "nextel.com"}},                                                 // Sprint
+	GlobalEquivalentDomains{Type: 5, Domains: []string{"wellsfargo.com", "wf.com", "wellsfargoadvisors.com"}},                                        // Wells Fargo
+	GlobalEquivalentDomains{Type: 6, Domains: []string{"mymerrill.com", "ml.com", "merrilledge.com"}},                                                // Merrill
+	GlobalEquivalentDomains{Type: 7, Domains: []string{"accountonline.com", "citi.com", "citibank.com", "citicards.com", "citibankonline.com"}},      // Citi
+	GlobalEquivalentDomains{Type: 8, Domains: []string{"cnet.com", "cnettv.com", "com.com", "download.com", "news.com", "search.com", "upload.com"}}, // CNET
+	GlobalEquivalentDomains{Type: 9, Domains: []string{"bananarepublic.com", "gap.com", "oldnavy.com", "piperlime.com"}},                             // Gap
+	GlobalEquivalentDomains{Type: 10, Domains: []string{"bing.com", "hotmail.com", "live.com", "microsoft.com", "msn.com", "passport.net", "windows.com", "microsoftonline.com", "office.com", "office365.com", "microsoftstore.com", "xbox.com", "azure.com", "windowsazure.com"}}, // Microsoft
+	GlobalEquivalentDomains{Type: 11, Domains: []string{"ua2go.com", "ual.com", "united.com", "unitedwifi.com"}},                                                                                                     // United
+	GlobalEquivalentDomains{Type: 12, Domains: []string{"overture.com", "yahoo.com"}},                                                                                                                                // Yahoo
+	GlobalEquivalentDomains{Type: 13, Domains: []string{"zonealarm.com", "zonelabs.com"}},                                                                                                                            // Zone Labs
+	GlobalEquivalentDomains{Type: 14, Domains: []string{"paypal.com", "paypal-search.com"}},                                                                                                                          // PayPal
+	GlobalEquivalentDomains{Type: 15, Domains: []string{"avon.com", "youravon.com"}},                                                                                                                                 // Avon
+	GlobalEquivalentDomains{Type: 16, Domains: []string{"diapers.com", "soap.com", "wag.com", "yoyo.com", "beautybar.com", "casa.com", "afterschool.com", "vine.com", "bookworm.com", "look.com", "vinemarket.com"}}, // Diapers.com
+	GlobalEquivalentDomains{Type: 17, Domains: []string{"1800contacts.com", "800contacts.com"}},                                                                                                                      // 1-800 Contacts
+	GlobalEquivalentDomains{Type: 18, Domains: []string{"amazon.com", "amazon.com.be", "amazon.ae", "amazon.ca", "amazon.co.uk", "amazon.com.au", "amazon.com.br", "amazon.com.mx", "amazon.com.tr", "amazon.de", "amazon.es", "amazon.fr", "amazon.in", "amazon.it", "amazon.nl", "amazon.pl", "amazon.sa", "amazon.se", "amazon.sg"}}, // Amazon
+	GlobalEquivalentDomains{Type: 19, Domains: []string{"cox.com", "cox.net", "coxbusiness.com"}},                                                                                     // Cox
+	GlobalEquivalentDomains{Type: 20, Domains: []string{"mynortonaccount.com", "norton.com"}},                                                                                         // Norton
+	GlobalEquivalentDomains{Type: 21, Domains: []string{"verizon.com", "verizon.net"}},                                                                                                // Verizon
+	GlobalEquivalentDomains{Type: 22, Domains: []string{"rakuten.com", "buy.com"}},                                                                                                    // Rakuten
+	GlobalEquivalentDomains{Type: 23, Domains: []string{"siriusxm.com", "sirius.com"}},                                                                                                // SiriusXM
+	GlobalEquivalentDomains{Type: 24, Domains: []string{"ea.com", "origin.com", "play4free.com", "tiberiumalliance.com"}},                                                             // EA
+	GlobalEquivalentDomains{Type: 25, Domains: []string{"37signals.com", "basecamp.com", "basecamphq.com", "highrisehq.com"}},                                                         // Basecamp
+	GlobalEquivalentDomains{Type: 26, Domains: []string{"steampowered.com", "steamcommunity.com", "steamgames.com"}},                                                                  // Steam
+	GlobalEquivalentDomains{Type: 27, Domains: []string{"chart.io", "chartio.com"}},                                                                                                   // Chartio
+	GlobalEquivalentDomains{Type: 28, Domains: []string{"gotomeeting.com", "citrixonline.com"}},                                                                                       // GoToMeeting
+	GlobalEquivalentDomains{Type: 29, Domains: []string{"gogoair.com", "gogoinflight.com"}},                                                                                           // Gogo
+	GlobalEquivalentDomains{Type: 30, Domains: []string{"mysql.com", "oracle.com"}},                                                                                                   // Oracle
+	GlobalEquivalentDomains{Type: 31, Domains: []string{"discover.com", "discovercard.com"}},                                                                                          // Discover
+	GlobalEquivalentDomains{Type: 32, Domains: []string{"dcu.org", "dcu-online.org"}},                                                                                                 // DCU
+	GlobalEquivalentDomains{Type: 33, Domains: []string{"healthcare.gov", "cms.gov"}},                                                                                                 // HealthCare.gov
+	GlobalEquivalentDomains{Type: 34, Domains: []string{"pepco.com", "pepcoholdings.com"}},                                                                                            // Pepco
+	GlobalEquivalentDomains{Type: 35, Domains: []string{"century21.com", "21online.com"}},                                                                                             // Century 21
+	GlobalEquivalentDomains{Type: 36, Domains: []string{"comcast.com", "comcast.net", "xfinity.com"}},                                                                                 // Comcast
+	GlobalEquivalentDomains{Type: 37, Domains: []string{"cricketwireless.com", "aiowireless.com"}},                                                                                    // Cricket
+	GlobalEquivalentDomains{Type: 38, Domains: []string{"mandtbank.com", "mtb.com"}},                                                                                                  // M&T Bank
+	GlobalEquivalentDomains{Type: 39, Domains: []string{"dropbox.com", "getdropbox.com"}},                                                                                             // Dropbox
+	GlobalEquivalentDomains{Type: 40, Domains: []string{"snapfish.com", "snapfish.ca"}},                                                                                               // Snapfish
+	GlobalEquivalentDomains{Type: 41, Domains: []string{"alibaba.com", "aliexpress.com", "aliyun.com", "net.cn"}},                                                                     // Alibaba
+	GlobalEquivalentDomains{Type: 42, Domains: []string{"playstation.com", "sonyentertainmentnetwork.com"}},                                                                           // PlayStation
+	GlobalEquivalentDomains{Type: 43, Domains: []string{"mercadolivre.com", "mercadolivre.com.br", "mercadolibre.com", "mercadolibre.com.ar", "mercadolibre.com.mx"}},                 // Mercado Libre
+	GlobalEquivalentDomains{Type: 44, Domains: []string{"zendesk.com", "zopim.com"}},                                                                                                  // Zendesk
+	GlobalEquivalentDomains{Type: 45, Domains: []string{"autodesk.com", "tinkercad.com"}},                                                                                             // Autodesk
+	GlobalEquivalentDomains{Type: 46, Domains: []string{"railnation.ru", "railnation.de", "rail-nation.com", "railnation.gr", "railnation.us", "trucknation.de", "traviangames.com"}}, // Rail Nation
+	GlobalEquivalentDomains{Type: 47, Domains: []string{"wpcu.coop", "wpcuonline.com"}},                                                                                               // WPCU
+	GlobalEquivalentDomains{Type: 48, Domains: []string{"mathletics.com", "mathletics.com.au", "mathletics.co.uk"}},                                                                   // Mathletics
+	GlobalEquivalentDomains{Type: 49, Domains: []string{"discountbank.co.il", "telebank.co.il"}},                                                                                      // Discount Bank
+	GlobalEquivalentDomains{Type: 50, Domains: []string{"mi.com", "xiaomi.com"}},                                                                                                      // Xiaomi
+	GlobalEquivalentDomains{Type: 51, Domains: []string{"facebook.com", "messenger.com"}},                                                                                             // Facebook
+	GlobalEquivalentDomains{Type: 52, Domains: []string{"postepay.it", "poste.it"}},                                                                                                   // Postepay
+	GlobalEquivalentDomains{Type: 53, Domains: []string{"skysports.com", "skybet.com", "skyvegas.com"}},                                                                               // Sky Sports
+	GlobalEquivalentDomains{Type: 54, Domains: []string{"disneymoviesanywhere.com", "go.com", "disney.com", "dadt.com", "disneyplus.com"}},                                            // Disney
+	GlobalEquivalentDomains{Type: 55, Domains: []string{"pokemon-gl.com", "pokemon.com"}},                                                                                             // Pokemon
+	GlobalEquivalentDomains{Type: 56, Domains: []string{"myuv.com", "uvvu.com"}},                                                                                                      // UltraViolet
+	GlobalEquivalentDomains{Type: 57, Domains: []string{"bank-yahav.co.il", "bankhapoalim.co.il"}},                                                                                    // Bank Yahav
+	GlobalEquivalentDomains{Type: 58, Domains: []string{"mdsol.com", "imedidata.com"}},                                                                                                // Medidata
+	GlobalEquivalentDomains{Type: 59, Domains: []string{"sears.com", "shld.net"}},                                                                                                     // Sears
+	GlobalEquivalentDomains{Type: 60, Domains: []string{"xiami.com", "alipay.com"}},                                                                                                   // Xiami
+	GlobalEquivalentDomains{Type: 61, Domains: []string{"belkin.com", "seedonk.com"}},                                                                                                 // Belkin
+	GlobalEquivalentDomains{Type: 62, Domains: []string{"turbotax.com", "intuit.com"}},                                                                                                // TurboTax
+	GlobalEquivalentDomains{Type: 63, Domains: []string{"shopify.com", "myshopify.com"}},                                                                                              // Shopify
+	GlobalEquivalentDomains{Type: 64, Domains: []string{"ebay.com", "ebay.at", "ebay.be", "ebay.ca", "ebay.ch", "ebay.cn", "ebay.co.jp", "ebay.co.th", "ebay.co.uk", "ebay.com.au", "ebay.com.hk", "ebay.com.my", "ebay.com.sg", "ebay.com.tw", "ebay.de", "ebay.es", "ebay.fr", "ebay.ie", "ebay.in", "ebay.it", "ebay.nl", "ebay.ph", "ebay.pl"}}, // eBay
+	GlobalEquivalentDomains{Type: 65, Domains: []string{"techdata.com", "techdata.ch"}},                                                        // Tech Data
+	GlobalEquivalentDomains{Type: 66, Domains: []string{"schwab.com", "schwabplan.com"}},                                                       // Schwab
+	GlobalEquivalentDomains{Type: 68, Domains: []string{"tesla.com", "teslamotors.com"}},                                                       // Tesla
+	GlobalEquivalentDomains{Type: 69, Domains: []string{"morganstanley.com", "morganstanleyclientserv.com", "stockplanconnect.com", "ms.com"}}, // Morgan Stanley
+	GlobalEquivalentDomains{Type: 70, Domains: []string{"taxact.com", "taxactonline.com"}},                                                     // TaxAct
+	GlobalEquivalentDomains{Type: 71, Domains: []string{"mediawiki.org", "wikibooks.org", "wikidata.org", "wikimedia.org", "wikinews.org", "wikipedia.org", "wikiquote.org", "wikisource.org", "wikiversity.org", "wikivoyage.org", "wiktionary.org"}}, // Wikimedia
+	GlobalEquivalentDomains{Type: 72, Domains: []string{"airbnb.at", "airbnb.be", "airbnb.ca", "airbnb.ch", "airbnb.cl", "airbnb.co.cr", "airbnb.co.id", "airbnb.co.in", "airbnb.co.kr", "airbnb.co.nz", "airbnb.co.uk", "airbnb.co.ve", "airbnb.com", "airbnb.com.ar", "airbnb.com.au", "airbnb.com.bo", "airbnb.com.br", "airbnb.com.bz", "airbnb.com.co", "airbnb.com.ec", "airbnb.com.gt", "airbnb.com.hk", "airbnb.com.hn", "airbnb.com.mt", "airbnb.com.my", "airbnb.com.ni", "airbnb.com.pa", "airbnb.com.pe", "airbnb.com.py", "airbnb.com.sg", "airbnb.com.sv", "airbnb.com.tr", "airbnb.com.tw", "airbnb.cz", "airbnb.de", "airbnb.dk", "airbnb.es", "airbnb.fi", "airbnb.fr", "airbnb.gr", "airbnb.gy", "airbnb.hu", "airbnb.ie", "airbnb.is", "airbnb.it", "airbnb.jp", "airbnb.mx", "airbnb.nl", "airbnb.no", "airbnb.pl", "airbnb.pt", "airbnb.ru", "airbnb.se"}}, // Airbnb
+	GlobalEquivalentDomains{Type: 73, Domains: []string{"eventbrite.at", "eventbrite.be", "eventbrite.ca", "eventbrite.ch", "eventbrite.cl", "eventbrite.co", "eventbrite.co.nz", "eventbrite.co.uk", "eventbrite.com", "eventbrite.com.ar", "eventbrite.com.au", "eventbrite.com.br", "eventbrite.com.mx", "eventbrite.com.pe", "eventbrite.de", "eventbrite.dk", "eventbrite.es", "eventbrite.fi", "eventbrite.fr", "eventbrite.hk", "eventbrite.ie", "eventbrite.it", "eventbrite.nl", "eventbrite.pt", "eventbrite.se", "eventbrite.sg"}},                                                                                                                                                                                                                                                                                                                                   // Eventbrite
+	GlobalEquivalentDomains{Type: 74, Domains: []string{"stackexchange.com", "superuser.com", "stackoverflow.com", "serverfault.com", "mathoverflow.net", "askubuntu.com", "stackapps.com"}},        // Stack Exchange
+	GlobalEquivalentDomains{Type: 75, Domains: []string{"docusign.com", "docusign.net"}},                                                                                                            // DocuSign
+	GlobalEquivalentDomains{Type: 76, Domains: []string{"envato.com", "themeforest.net", "codecanyon.net", "videohive.net", "audiojungle.net", "graphicriver.net", "photodune.net", "3docean.net"}}, // Envato
+	GlobalEquivalentDomains{Type: 77, Domains: []string{"x10hosting.com", "x10premium.com"}},                                                                                                        // x10Hosting
+	GlobalEquivalentDomains{Type: 78, Domains: []string{"dnsomatic.com", "opendns.com", "umbrella.com"}},                                                                                            // Cisco Umbrella
+	GlobalEquivalentDomains{Type: 79, Domains: []string{"cagreatamerica.com", "canadaswonderland.com", "carowinds.com", "cedarfair.com", "cedarpoint.com", "dorneypark.com", "kingsdominion.com", "knotts.com", "miadventure.com", "schlitterbahn.com", "valleyfair.com", "visitkingsisland.com", "worldsoffun.com"}}, // Cedar Fair
+	GlobalEquivalentDomains{Type: 80, Domains: []string{"ubnt.com", "ui.com"}},                                // Ubiquiti
+	GlobalEquivalentDomains{Type: 81, Domains: []string{"discordapp.com", "discord.com"}},                     // Discord
+	GlobalEquivalentDomains{Type: 82, Domains: []string{"netcup.de", "netcup.eu", "customercontrolpanel.de"}}, // netcup
+	GlobalEquivalentDomains{Type: 83, Domains: []string{"yandex.com", "ya.ru", "yandex.az", "yandex.by", "yandex.co.il", "yandex.com.am", "yandex.com.ge", "yandex.com.tr", "yandex.ee", "yandex.fi", "yandex.fr", "yandex.kg", "yandex.kz", "yandex.lt", "yandex.lv", "yandex.md", "yandex.pl", "yandex.ru", "yandex.tj", "yandex.tm", "yandex.ua", "yandex.uz"}}, // Yandex
+	GlobalEquivalentDomains{Type: 84, Domains: []string{"sonyentertainmentnetwork.com", "sony.com"}},                                                                                                                                                                                // Sony
+	GlobalEquivalentDomains{Type: 85, Domains: []string{"proton.me", "protonmail.com", "protonvpn.com"}},                                                                                                                                                                            // Proton
+	GlobalEquivalentDomains{Type: 86, Domains: []string{"ubisoft.com", "ubi.com"}},                                                                                                                                                                                                  // Ubisoft
+	GlobalEquivalentDomains{Type: 87, Domains: []string{"transferwise.com", "wise.com"}},                                                                                                                                                                                            // Wise
+	GlobalEquivalentDomains{Type: 88, Domains: []string{"takeaway.com", "just-eat.dk", "just-eat.no", "just-eat.fr", "just-eat.ch", "lieferando.de", "lieferando.at", "thuisbezorgd.nl", "pyszne.pl"}},                                                                              // Takeaway.com
+	GlobalEquivalentDomains{Type: 89, Domains: []string{"atlassian.com", "bitbucket.org", "trello.com", "statuspage.io", "atlassian.net", "jira.com"}},                                                                                                                              // Atlassian
+	GlobalEquivalentDomains{Type: 90, Domains: []string{"pinterest.com", "pinterest.com.au", "pinterest.cl", "pinterest.de", "pinterest.dk", "pinterest.es", "pinterest.fr", "pinterest.co.uk", "pinterest.jp", "pinterest.co.kr", "pinterest.nz", "pinterest.pt", "pinterest.se"}}, // Pinterest
+}
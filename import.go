@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type importData struct {
+	Folders []struct {
+		Name string `json:"name"`
+	} `json:"folders"`
+	Ciphers             []newCipher `json:"ciphers"`
+	FolderRelationships []struct {
+		Key   int `json:"key"`   // Index of the cipher
+		Value int `json:"value"` // Index of the folder
+	} `json:"folderRelationships"`
+}
+
+// handleImport adds the folders and ciphers exported from another password
+// manager. Either everything is imported or nothing.
+func handleImport(w http.ResponseWriter, req *http.Request, acc Account) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	log.Println(acc.Email + " is trying to import data")
+
+	var imp importData
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxImportSize)).Decode(&imp)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	if len(imp.Ciphers)+len(imp.Folders) > maxImportItems {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println("Import too large")
+		return
+	}
+
+	folders := make([]Folder, len(imp.Folders))
+	for i, f := range imp.Folders {
+		err = validateEncFields([]encField{{fmt.Sprintf("Folders[%d].Name", i), f.Name, true}})
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		folders[i] = Folder{Name: f.Name, Object: "folder"}
+	}
+
+	device := deviceName(req)
+	ciphs := make([]Cipher, len(imp.Ciphers))
+	for i := range imp.Ciphers {
+		ciphs[i], err = imp.Ciphers[i].toCipher()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(400)))
+			log.Println(err)
+			return
+		}
+
+		err = validateCipher(ciphs[i])
+		if err != nil {
+			verr := err.(*validationError)
+			verr.field = fmt.Sprintf("Ciphers[%d].%s", i, verr.field)
+			writeValidationError(w, verr)
+			return
+		}
+		ciphs[i].Device = device
+	}
+
+	folderOf := make(map[int]int)
+	for _, r := range imp.FolderRelationships {
+		if r.Key < 0 || r.Key >= len(ciphs) || r.Value < 0 || r.Value >= len(folders) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(400)))
+			log.Println("Invalid folder relationship")
+			return
+		}
+		folderOf[r.Key] = r.Value
+	}
+
+	err = db.importVault(folders, ciphs, folderOf, acc.Id)
+	if err == errQuotaExceeded {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(500)))
+		log.Println(err)
+		return
+	}
+
+	pushUser(req, pushSyncVault, acc.Id)
+
+	log.Printf("Imported %d folders and %d ciphers\n", len(folders), len(ciphs))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleImport(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+
+	folders := `"folders":[{"name":"` + testEncString("folder 0") + `"},{"name":"` + testEncString("folder 1") + `"}]`
+	ciphers := `"ciphers":[{"type":1,"name":"` + testEncString("cipher 0") + `","login":{}},{"type":1,"name":"` + testEncString("cipher 1") + `","login":{}},{"type":1,"name":"` + testEncString("cipher 2") + `","login":{}}]`
+
+	// Nothing is imported with a relationship out of range
+	for _, rel := range []string{`{"key":3,"value":0}`, `{"key":0,"value":2}`, `{"key":-1,"value":0}`} {
+		res := httptest.NewRecorder()
+		handleImport(res, testRequest(t, acc, "POST", "/api/ciphers/import", `{`+folders+`,`+ciphers+`,"folderRelationships":[`+rel+`]}`), acc)
+		if res.Code != 400 {
+			t.Errorf("Expected 400 for relationship %v got %v", rel, res.Code)
+		}
+	}
+	ciphs, err := d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 0 {
+		t.Fatalf("Expected nothing to be imported got %v ciphers", len(ciphs))
+	}
+
+	res := httptest.NewRecorder()
+	handleImport(res, testRequest(t, acc, "POST", "/api/ciphers/import", `{`+folders+`,`+ciphers+`,"folderRelationships":[{"key":0,"value":1},{"key":2,"value":0}]}`), acc)
+	if res.Code != 200 {
+		t.Fatalf("Expected 200 got %v", res.Code)
+	}
+
+	fs, err := d.getFolders(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folderNames := make(map[string]string)
+	for _, f := range fs {
+		folderNames[f.Id] = f.Name
+	}
+	if len(folderNames) != 2 {
+		t.Fatalf("Expected 2 folders got %v", fs)
+	}
+
+	ciphs, err = d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 3 {
+		t.Fatalf("Expected 3 ciphers got %v", len(ciphs))
+	}
+	expected := map[string]string{
+		testEncString("cipher 0"): testEncString("folder 1"),
+		testEncString("cipher 1"): "",
+		testEncString("cipher 2"): testEncString("folder 0"),
+	}
+	for _, ciph := range ciphs {
+		folder := ""
+		if ciph.FolderId != nil {
+			folder = folderNames[*ciph.FolderId]
+		}
+		if folder != expected[ciph.Data.Name] {
+			t.Errorf("Expected %v in folder %q got %q", ciph.Data.Name, expected[ciph.Data.Name], folder)
+		}
+	}
+}
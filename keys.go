@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type keyRotationData struct {
+	MasterPasswordHash string      `json:"masterPasswordHash"`
+	Key                string      `json:"key"`
+	PrivateKey         string      `json:"privateKey"`
+	Ciphers            []newCipher `json:"ciphers"`
+	Folders            []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+}
+
+// handleKeyRotation stores a new encryption key for the account with all the
+// ciphers and folders re-encrypted with it. Either everything is changed or
+// nothing. The other sessions are logged out.
+func handleKeyRotation(w http.ResponseWriter, req *http.Request) {
+	email := req.Context().Value(ctxKey("email")).(string)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	log.Println(email + " is trying to rotate the encryption key")
+
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		log.Fatal("Account lookup " + err.Error())
+	}
+
+	var data keyRotationData
+	err = json.NewDecoder(http.MaxBytesReader(w, req.Body, maxImportSize)).Decode(&data)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	if data.MasterPasswordHash != acc.MasterPasswordHash {
+		writeValidationError(w, &validationError{"MasterPasswordHash", errors.New("Invalid password.")})
+		return
+	}
+
+	err = validateEncFields([]encField{{"Key", data.Key, true}, {"PrivateKey", data.PrivateKey, false}})
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	folders := make([]Folder, len(data.Folders))
+	for i, f := range data.Folders {
+		err = validateEncFields([]encField{{fmt.Sprintf("Folders[%d].Name", i), f.Name, true}})
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		folders[i] = Folder{Id: f.Id, Name: f.Name, Object: "folder"}
+	}
+
+	ciphs := make([]Cipher, len(data.Ciphers))
+	for i := range data.Ciphers {
+		ciphs[i], err = data.Ciphers[i].toCipher()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(400)))
+			log.Println(err)
+			return
+		}
+
+		err = validateCipher(ciphs[i])
+		if err != nil {
+			verr := err.(*validationError)
+			verr.field = fmt.Sprintf("Ciphers[%d].%s", i, verr.field)
+			writeValidationError(w, verr)
+			return
+		}
+	}
+
+	acc.Key = data.Key
+	acc.PrivateKey = data.PrivateKey
+	err = db.rotateKey(acc, ciphs, folders)
+	if err == errKeyRotationMismatch {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(500)))
+		log.Println(err)
+		return
+	}
+
+	// The other devices have to log in again to get the new key
+	pushUser(req, pushLogOut, acc.Id)
+
+	log.Println("Key rotated for " + email)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandleKeyRotation(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+
+	err := d.addAccount(Account{Name: "nobody", Email: "nobody@example.com", MasterPasswordHash: "hash", Key: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := d.getAccount("nobody@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.updateAccountInfo(acc.Id, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciph, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotate := func(hash string, ciphIDs ...string) *httptest.ResponseRecorder {
+		var ciphs []string
+		for _, id := range ciphIDs {
+			ciphs = append(ciphs, `{"id":"`+id+`","type":1,"name":"`+testEncString("name")+`"}`)
+		}
+		body := `{"masterPasswordHash":"` + hash + `","key":"` + testEncString("key") + `","privateKey":"` + testEncString("private") + `","ciphers":[` + strings.Join(ciphs, ",") + `],"folders":[]}`
+
+		res := httptest.NewRecorder()
+		handleKeyRotation(res, testRequest(t, acc, "POST", "/api/accounts/key", body))
+		return res
+	}
+
+	res := rotate("wrong", ciph.Id)
+	if res.Code != 400 {
+		t.Errorf("Expected 400 for a wrong password got %v", res.Code)
+	}
+	res = rotate("hash")
+	if res.Code != 400 || !strings.Contains(res.Body.String(), errKeyRotationMismatch.Error()) {
+		t.Errorf("Expected 400 without the cipher got %v %s", res.Code, res.Body.Bytes())
+	}
+	res = rotate("hash", ciph.Id, ciph.Id)
+	if res.Code != 400 {
+		t.Errorf("Expected 400 for a duplicate cipher got %v", res.Code)
+	}
+
+	res = rotate("hash", ciph.Id)
+	if res.Code != 200 {
+		t.Fatalf("Expected 200 got %v %s", res.Code, res.Body.Bytes())
+	}
+
+	rotated, err := d.getAccount(acc.Email, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Key != testEncString("key") || rotated.PrivateKey != testEncString("private") || rotated.SecurityStamp == acc.SecurityStamp {
+		t.Errorf("Expected the new keys and security stamp got %+v", rotated)
+	}
+
+	// The other sessions have to log in again
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"refresh"}}
+	req, err := http.NewRequest("POST", "/identity/connect/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+	handleLogin(res, req)
+	if res.Code != 400 || !strings.Contains(res.Body.String(), "invalid_grant") {
+		t.Errorf("Expected invalid_grant for the revoked refresh token got %v %s", res.Code, res.Body.Bytes())
+	}
+	if validSecurityStamp(acc.Email, acc.SecurityStamp) {
+		t.Error("Expected the old security stamp to be rejected")
+	}
+}
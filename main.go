@@ -1,28 +1,80 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"flag"
+	"io"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // The data we get from the client. Only used to parse data
 type newCipher struct {
-	Type           int       `json:"type"`
-	FolderId       string    `json:"folderId"`
-	OrganizationId string    `json:"organizationId"`
-	Name           string    `json:"name"`
-	Notes          string    `json:"notes"`
-	Favorite       bool      `json:"favorite"`
-	Login          loginData `json:"login"`
+	Id              string                    `json:"id"` // Only used by key rotation, otherwise the id is in the url
+	Type            int                       `json:"type"`
+	FolderId        string                    `json:"folderId"`
+	OrganizationId  string                    `json:"organizationId"`
+	Name            string                    `json:"name"`
+	Notes           string                    `json:"notes"`
+	Favorite        bool                      `json:"favorite"`
+	Reprompt        int                       `json:"reprompt"`
+	Login           loginData                 `json:"login"`
+	SshKey          *sshKeyData               `json:"sshKey"`
+	PasswordHistory []passwordHistoryData     `json:"passwordHistory"`
+	Attachments2    map[string]attachmentData `json:"attachments2"` // Attachment id to its re-encrypted name and key
+
+	LastKnownRevisionDate *time.Time `json:"lastKnownRevisionDate"` // The revision the client based its changes on
 }
 
 type loginData struct {
-	URI      string `json:"uri"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	ToTp     string `json:"totp"`
+	URI                  string         `json:"uri"`
+	URIs                 []loginURIData `json:"uris"`
+	Username             string         `json:"username"`
+	Password             string         `json:"password"`
+	PasswordRevisionDate *time.Time     `json:"passwordRevisionDate"`
+	ToTp                 string         `json:"totp"`
+
+	Fido2Credentials []fido2CredentialData `json:"fido2Credentials"`
+}
+
+type fido2CredentialData struct {
+	CredentialId    string    `json:"credentialId"`
+	KeyType         string    `json:"keyType"`
+	KeyAlgorithm    string    `json:"keyAlgorithm"`
+	KeyCurve        string    `json:"keyCurve"`
+	KeyValue        string    `json:"keyValue"`
+	RpId            string    `json:"rpId"`
+	RpName          string    `json:"rpName"`
+	UserHandle      string    `json:"userHandle"`
+	UserName        string    `json:"userName"`
+	UserDisplayName string    `json:"userDisplayName"`
+	Counter         string    `json:"counter"`
+	Discoverable    string    `json:"discoverable"`
+	CreationDate    time.Time `json:"creationDate"`
+}
+
+type sshKeyData struct {
+	PrivateKey     string `json:"privateKey"`
+	PublicKey      string `json:"publicKey"`
+	KeyFingerprint string `json:"keyFingerprint"`
+}
+
+type loginURIData struct {
+	URI   string `json:"uri"`
+	Match *int   `json:"match"` // null means the client default (domain)
+}
+
+type passwordHistoryData struct {
+	Password     string    `json:"password"`
+	LastUsedDate time.Time `json:"lastUsedDate"`
+}
+
+type attachmentData struct {
+	FileName string `json:"fileName"`
+	Key      string `json:"key"`
 }
 
 func handleNewCipher(w http.ResponseWriter, req *http.Request) {
@@ -37,14 +89,39 @@ func handleNewCipher(w http.ResponseWriter, req *http.Request) {
 
 	rCiph, err := unmarshalCipher(req.Body)
 	if err != nil {
-		log.Fatal("Cipher decode error" + err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println("Cipher decode error " + err.Error())
+		return
+	}
+
+	err = validateCipher(rCiph)
+	if err != nil {
+		writeValidationError(w, err)
+		return
 	}
 
+	rCiph.Device = deviceName(req)
+
 	// Store the new cipher object in db
 	newCiph, err := db.newCipher(rCiph, acc.Id)
+	if err == errQuotaExceeded {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err == errFolderNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(404)))
+		log.Println(err)
+		return
+	}
 	if err != nil {
-		log.Fatal("newCipher error" + err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(500)))
+		log.Println(err)
+		return
 	}
+	pushCipher(req, pushSyncCipherCreate, acc.Id, newCiph.Id)
 
 	data, err := json.Marshal(&newCiph)
 	if err != nil {
@@ -61,49 +138,123 @@ func handleCipherUpdate(w http.ResponseWriter, req *http.Request) {
 	log.Println(email + " is trying to edit his data")
 
 	// Get the cipher id
-	id := req.URL.Path[len("/api/ciphers/"):]
+	parts := strings.Split(req.URL.Path[len("/api/ciphers/"):], "/")
+	id := parts[0]
 
 	acc, err := db.getAccount(email, "")
 	if err != nil {
 		log.Fatal("Account lookup " + err.Error())
 	}
 
+	if len(parts) == 1 {
+		switch id {
+		case "move", "delete", "restore", "share":
+			handleBulkCiphers(w, req, acc, id)
+			return
+		case "import":
+			handleImport(w, req, acc)
+			return
+		}
+	}
+
+	if req.Method == "GET" && (len(parts) == 1 || (len(parts) == 2 && parts[1] == "details")) {
+		writeCipher(w, acc, id)
+		return
+	}
+
+	if len(parts) > 1 {
+		switch {
+		case parts[1] == "attachment" && len(parts) == 2:
+			handleAttachment(w, req, acc, id, "")
+		case parts[1] == "attachment" && len(parts) == 3:
+			handleAttachment(w, req, acc, id, parts[2])
+		case parts[1] == "revisions" && len(parts) == 2:
+			handleCipherRevisions(w, req, acc, id, "")
+		case parts[1] == "revisions" && len(parts) == 4 && parts[3] == "restore":
+			handleCipherRevisions(w, req, acc, id, parts[2])
+		case parts[1] == "partial" && len(parts) == 2 && (req.Method == "PUT" || req.Method == "POST"):
+			handleCipherPartial(w, req, acc, id)
+		case parts[1] == "delete" && len(parts) == 2 && req.Method == "PUT":
+			handleCipherTrash(w, req, acc, id, true)
+		case parts[1] == "restore" && len(parts) == 2 && req.Method == "PUT":
+			handleCipherTrash(w, req, acc, id, false)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+		}
+		return
+	}
+
 	switch req.Method {
 	case "PUT":
 		rCiph, err := unmarshalCipher(req.Body)
 		if err != nil {
-			log.Fatal("Cipher decode error" + err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(400)))
+			log.Println("Cipher decode error " + err.Error())
+			return
+		}
+
+		err = validateCipher(rCiph)
+		if err != nil {
+			writeValidationError(w, err)
+			return
 		}
 
 		// Set correct ID
 		rCiph.Id = id
+		rCiph.Device = deviceName(req)
 
 		err = db.updateCipher(rCiph, acc.Id, id)
-		if err != nil {
-			w.Write([]byte("0"))
+		if err == errCipherConflict || err == errQuotaExceeded {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err == errFolderNotFound || err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
 			log.Println(err)
 			return
 		}
-
-		// Send response
-		data, err := json.Marshal(&rCiph)
 		if err != nil {
-			log.Fatal(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(500)))
+			log.Println(err)
+			return
 		}
+		pushCipher(req, pushSyncCipherUpdate, acc.Id, id)
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(data)
 		log.Println("Cipher " + id + " updated")
+		writeCipher(w, acc, id)
 		return
 
 	case "DELETE":
-		err := db.deleteCipher(acc.Id, id)
+		// For the UUID of the notification
+		ciph, err := db.getCipher(acc.Id, id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+
+		attachments, err := db.getAttachments(acc.Id, id)
+		if err != nil {
+			w.Write([]byte("0"))
+			log.Println(err)
+			return
+		}
+
+		err = db.deleteCiphers(acc.Id, []string{id})
 		if err != nil {
 			w.Write([]byte("0"))
 			log.Println(err)
 			return
 		}
 
+		removeAttachmentBlobs(attachments)
+		pushCipher(req, pushSyncCipherDelete, acc.Id, ciph.Id)
+
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(""))
 		log.Println("Cipher " + id + " deleted")
@@ -115,6 +266,141 @@ func handleCipherUpdate(w http.ResponseWriter, req *http.Request) {
 
 }
 
+// handleCipherPartial changes the folder and favorite without the client
+// having to send the whole cipher
+func handleCipherPartial(w http.ResponseWriter, req *http.Request, acc Account, ciphID string) {
+	var partial struct {
+		FolderId string `json:"folderId"`
+		Favorite bool   `json:"favorite"`
+	}
+
+	err := json.NewDecoder(req.Body).Decode(&partial)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	err = db.updateCipherPartial(acc.Id, ciphID, nullString(partial.FolderId), partial.Favorite)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(404)))
+		log.Println(err)
+		return
+	}
+
+	pushCipher(req, pushSyncCipherUpdate, acc.Id, ciphID)
+
+	log.Println("Cipher " + ciphID + " updated")
+	writeCipher(w, acc, ciphID)
+}
+
+// writeCipher sends the stored version of one of the accounts ciphers
+func writeCipher(w http.ResponseWriter, acc Account, ciphID string) {
+	ciph, err := db.getCipher(acc.Id, ciphID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(404)))
+		log.Println(err)
+		return
+	}
+
+	ciphs := []Cipher{ciph}
+	setAttachmentURLs(ciphs)
+
+	data, err := json.Marshal(&ciphs[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// writeList sends the items in the list object the clients expect
+func writeList(w http.ResponseWriter, items interface{}) {
+	data, err := json.Marshal(&struct {
+		Data   interface{}
+		Object string
+	}{items, "list"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// This function handles /api/ciphers
+func handleCiphers(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		handleNewCipher(w, req)
+		return
+	}
+
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	email := req.Context().Value(ctxKey("email")).(string)
+
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		log.Fatal("Account lookup " + err.Error())
+	}
+
+	ciphs, err := db.getCiphers(acc.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(500)))
+		log.Println(err)
+		return
+	}
+	setAttachmentURLs(ciphs)
+
+	// Ciphers in the trash are only sent with sync
+	active := make([]Cipher, 0, len(ciphs))
+	for _, ciph := range ciphs {
+		if ciph.DeletedDate == nil {
+			active = append(active, ciph)
+		}
+	}
+
+	writeList(w, active)
+}
+
+// handleRevisionDate returns when anything in the vault was last changed, in
+// milliseconds since the epoch. Clients only sync if it's after their last sync.
+func handleRevisionDate(w http.ResponseWriter, req *http.Request) {
+	email := req.Context().Value(ctxKey("email")).(string)
+
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(401)))
+		log.Println(err)
+		return
+	}
+
+	data, err := json.Marshal(unixMilli(acc.RevisionDate))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
 func handleSync(w http.ResponseWriter, req *http.Request) {
 	email := req.Context().Value(ctxKey("email")).(string)
 
@@ -130,32 +416,29 @@ func handleSync(w http.ResponseWriter, req *http.Request) {
 		Culture:          "en-US",
 		TwoFactorEnabled: false,
 		Key:              acc.Key,
-		SecurityStamp:    "123",
+		PrivateKey:       acc.PrivateKey,
+		SecurityStamp:    acc.SecurityStamp,
+		RevisionDate:     acc.RevisionDate,
 		Organizations:    nil,
 		Object:           "profile",
 	}
+	setProfileStorage(&prof, acc)
 
 	ciphs, err := db.getCiphers(acc.Id)
 	if err != nil {
 		log.Println(err)
 	}
+	setAttachmentURLs(ciphs)
 
 	folders, err := db.getFolders(acc.Id)
 	if err != nil {
 		log.Println(err)
 	}
 
-	Domains := Domains{
-		EquivalentDomains: nil,
-		GlobalEquivalentDomains: []GlobalEquivalentDomains{
-			GlobalEquivalentDomains{Type: 1, Domains: []string{"youtube.com", "google.com", "gmail.com"}, Excluded: false},
-		},
-	}
-
 	data := SyncData{
 		Profile: prof,
 		Folders: folders,
-		Domains: Domains,
+		Domains: accountDomains(acc, false),
 		Object:  "sync",
 		Ciphers: ciphs,
 	}
@@ -191,10 +474,138 @@ func handleNewFolder(w http.ResponseWriter, req *http.Request) {
 	}
 	defer req.Body.Close()
 
+	err = validateEncFields([]encField{{"Name", folderData.Name, true}})
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
 	folder, err := db.addFolder(folderData.Name, acc.Id)
 	if err != nil {
 		log.Fatal("newFolder error" + err.Error())
 	}
+	pushFolder(req, pushSyncFolderCreate, acc.Id, folder.Id)
+
+	data, err := json.Marshal(&folder)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// This function handles /api/folders
+func handleFolders(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		handleNewFolder(w, req)
+		return
+	}
+
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	email := req.Context().Value(ctxKey("email")).(string)
+
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		log.Fatal("Account lookup " + err.Error())
+	}
+
+	folders, err := db.getFolders(acc.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(500)))
+		log.Println(err)
+		return
+	}
+
+	writeList(w, folders)
+}
+
+// This function handles /api/folders/{id} and /api/folders/{id}/delete
+func handleFolder(w http.ResponseWriter, req *http.Request) {
+	email := req.Context().Value(ctxKey("email")).(string)
+
+	id := req.URL.Path[len("/api/folders/"):]
+	action := ""
+	if i := strings.Index(id, "/"); i >= 0 {
+		id, action = id[:i], id[i+1:]
+	}
+
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		log.Fatal("Account lookup " + err.Error())
+	}
+
+	switch {
+	case action == "" && req.Method == "GET":
+
+	case action == "" && (req.Method == "PUT" || req.Method == "POST"):
+		log.Println(email + " is trying to rename folder " + id)
+
+		var folderData struct {
+			Name string `json:"name"`
+		}
+
+		err = json.NewDecoder(req.Body).Decode(&folderData)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(400)))
+			log.Println(err)
+			return
+		}
+		defer req.Body.Close()
+
+		err = validateEncFields([]encField{{"Name", folderData.Name, true}})
+		if err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		err = db.updateFolder(acc.Id, id, folderData.Name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+		pushFolder(req, pushSyncFolderUpdate, acc.Id, id)
+
+	case (action == "" && req.Method == "DELETE") || (action == "delete" && req.Method == "POST"):
+		log.Println(email + " is trying to delete folder " + id)
+
+		// The ciphers in the folder are kept
+		err = db.deleteFolder(acc.Id, id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+		pushFolder(req, pushSyncFolderDelete, acc.Id, id)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(""))
+		log.Println("Folder " + id + " deleted")
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	folder, err := db.getFolder(acc.Id, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(404)))
+		log.Println(err)
+		return
+	}
 
 	data, err := json.Marshal(&folder)
 	if err != nil {
@@ -208,21 +619,61 @@ func handleNewFolder(w http.ResponseWriter, req *http.Request) {
 // Interface to make testing easier
 type database interface {
 	init() error
+	migrate() error
 	addAccount(acc Account) error
 	getAccount(username string, refreshtoken string) (Account, error)
+	getAccounts() ([]Account, error)
+	restoreAccounts(accounts []backupAccount, putBlobs func(ids map[string]string) error) error
 	updateAccountInfo(sid string, refreshToken string) error
+	rotateKey(acc Account, ciphs []Cipher, folders []Folder) error
+	getUsage(owner string) (Usage, error)
+	setQuota(email string, quota Quota) error
+	setDomainSettings(owner string, settings DomainSettings) error
 	getCiphers(owner string) ([]Cipher, error)
+	getCipher(owner string, ciphID string) (Cipher, error)
 	newCipher(ciph Cipher, owner string) (Cipher, error)
+	importVault(folders []Folder, ciphs []Cipher, folderOf map[int]int, owner string) error
 	updateCipher(newData Cipher, owner string, ciphID string) error
-	deleteCipher(owner string, ciphID string) error
+	updateCipherPartial(owner string, ciphID string, folderID *string, favorite bool) error
+	deleteCiphers(owner string, ciphIDs []string) error
+	trashCiphers(owner string, ciphIDs []string) error
+	restoreCiphers(owner string, ciphIDs []string) error
+	moveCiphers(owner string, ciphIDs []string, folderID *string) error
+	purgeCiphers(deletedBefore time.Time) ([]Attachment, error)
+	getCipherRevisions(owner string, ciphID string) ([]CipherRevision, error)
+	restoreCipherRevision(owner string, ciphID string, revID string, device string) error
 	open() error
 	close()
 	addFolder(name string, owner string) (Folder, error)
 	getFolders(owner string) ([]Folder, error)
+	getFolder(owner string, folderID string) (Folder, error)
+	updateFolder(owner string, folderID string, name string) error
+	deleteFolder(owner string, folderID string) error
+	addAttachment(att Attachment, owner string) error
+	getAttachments(owner string, ciphID string) ([]Attachment, error)
+	getAttachment(owner string, ciphID string, attID string) (Attachment, error)
+	deleteAttachment(owner string, ciphID string, attID string) error
+	getUnmovedCiphers() ([]legacyCipher, error)
+	setBlobsMoved(ciphID string) error
+}
+
+// Where the attachment files are stored
+type blobStore interface {
+	put(key string, r io.Reader, size int64) error
+	get(key string) (io.ReadCloser, error)
+	delete(key string) error
+	url(key string) (string, error) // Direct download url, empty if the server should serve the file
 }
 
 func main() {
 	initDB := flag.Bool("init", false, "Initialize the database")
+	exportFile := flag.String("export", "", "Export accounts to a backup file and exit")
+	importFile := flag.String("import", "", "Import accounts from a backup file into an empty database and exit")
+	exportAccount := flag.String("account", "", "Email of the account to export, all accounts if empty")
+	quotaAccount := flag.String("quota", "", "Email of an account to change the storage limits of and exit")
+	quotaItems := flag.Int64("quota-items", -1, "Maximum number of items with -quota, 0 for unlimited and -1 for the default")
+	quotaData := flag.Int64("quota-data", -1, "Maximum bytes of item data with -quota, 0 for unlimited and -1 for the default")
+	quotaAttachments := flag.Int64("quota-attachments", -1, "Maximum bytes of attachments with -quota, 0 for unlimited and -1 for the default")
 	flag.Parse()
 
 	err := db.open()
@@ -240,16 +691,74 @@ func main() {
 		}
 	}
 
+	err = db.migrate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Tried again on every start until all of them are moved
+	moveAttachmentBlobs()
+
+	if *exportFile != "" {
+		err := exportBackup(*exportFile, *exportAccount)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *quotaAccount != "" {
+		// Only the limits that are given are changed
+		given := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+		change := func(name string, v *int64) *int64 {
+			if !given[name] {
+				return nil
+			}
+			return v
+		}
+
+		err := setAccountQuota(*quotaAccount, change("quota-items", quotaItems), change("quota-data", quotaData), change("quota-attachments", quotaAttachments))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *importFile != "" {
+		err := importBackup(*importFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if globalDomainsFile != "" {
+		err := loadGlobalDomains(globalDomainsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	http.HandleFunc("/api/accounts/register", handleRegister)
+	http.Handle("/api/accounts/key", jwtMiddleware(http.HandlerFunc(handleKeyRotation)))
+	http.Handle("/api/accounts/revision-date", jwtMiddleware(http.HandlerFunc(handleRevisionDate)))
+	http.Handle("/api/settings/domains", jwtMiddleware(http.HandlerFunc(handleDomainSettings)))
 	http.HandleFunc("/identity/connect/token", handleLogin)
 
-	http.Handle("/api/folders", jwtMiddleware(http.HandlerFunc(handleNewFolder)))
+	http.Handle("/api/folders", jwtMiddleware(http.HandlerFunc(handleFolders)))
+	http.Handle("/api/folders/", jwtMiddleware(http.HandlerFunc(handleFolder)))
 	http.Handle("/apifolders", jwtMiddleware(http.HandlerFunc(handleNewFolder))) // The android app want's the address like this, will be fixed in the next version. Issue #174
 	http.Handle("/api/sync", jwtMiddleware(http.HandlerFunc(handleSync)))
 
-	http.Handle("/api/ciphers", jwtMiddleware(http.HandlerFunc(handleNewCipher)))
+	http.Handle("/api/ciphers", jwtMiddleware(http.HandlerFunc(handleCiphers)))
 	http.Handle("/api/ciphers/", jwtMiddleware(http.HandlerFunc(handleCipherUpdate)))
 
+	http.HandleFunc("/notifications/hub", handleNotificationHub)
+	http.Handle("/notifications/hub/negotiate", jwtMiddleware(http.HandlerFunc(handleNotificationNegotiate)))
+
+	go purgeTrash()
+
 	log.Println("Starting server on " + serverAddr)
 	http.ListenAndServe(serverAddr, nil)
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testRequest returns a request with the json body as made by the account
+func testRequest(t *testing.T, acc Account, method string, url string, body string) *http.Request {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req.WithContext(context.WithValue(req.Context(), ctxKey("email"), acc.Email))
+}
+
+// testEncString returns a string in the format of the strings encrypted by
+// the clients, with s as the data so it can be told apart
+func testEncString(s string) string {
+	iv := make([]byte, 16)
+	data := make([]byte, 16)
+	copy(data, s)
+	mac := make([]byte, 32)
+	return "2." + base64.StdEncoding.EncodeToString(iv) + "|" + base64.StdEncoding.EncodeToString(data) + "|" + base64.StdEncoding.EncodeToString(mac)
+}
+
+func TestHandleCipherPartial(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	other := newTestAccount(t, d, "other@example.com")
+
+	ciph, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder, err := d.addFolder("name", acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFolder, err := d.addFolder("name", other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partial := func(acc Account, body string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handleCipherUpdate(res, testRequest(t, acc, "PUT", "/api/ciphers/"+ciph.Id+"/partial", body))
+		return res
+	}
+
+	res := partial(acc, `{"folderId":"`+folder.Id+`","favorite":true}`)
+	if res.Code != 200 {
+		t.Fatalf("Expected 200 got %v", res.Code)
+	}
+	var got Cipher
+	err = json.Unmarshal(res.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != ciph.Id || got.FolderId == nil || *got.FolderId != folder.Id || !got.Favorite {
+		t.Errorf("Expected the cipher as favorite in the folder got %s", res.Body.Bytes())
+	}
+
+	// Folders and ciphers of other accounts are not found
+	res = partial(acc, `{"folderId":"`+otherFolder.Id+`","favorite":false}`)
+	if res.Code != 404 {
+		t.Errorf("Expected 404 for a folder of another account got %v", res.Code)
+	}
+	res = partial(other, `{"folderId":"`+otherFolder.Id+`","favorite":false}`)
+	if res.Code != 404 {
+		t.Errorf("Expected 404 for a cipher of another account got %v", res.Code)
+	}
+
+	ciphs, err := d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 1 || ciphs[0].FolderId == nil || *ciphs[0].FolderId != folder.Id || !ciphs[0].Favorite {
+		t.Errorf("Expected the cipher to be unchanged got %+v", ciphs)
+	}
+}
+
+func TestReadEndpoints(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	other := newTestAccount(t, d, "other@example.com")
+
+	ciph, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.trashCiphers(acc.Id, []string{trashed.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder, err := d.addFolder("name", acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCiph, err := d.newCipher(Cipher{Type: 1}, other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFolder, err := d.addFolder("name", other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(handler http.HandlerFunc, url string, v interface{}) int {
+		res := httptest.NewRecorder()
+		handler(res, testRequest(t, acc, "GET", url, ""))
+		if res.Code == 200 {
+			err := json.Unmarshal(res.Body.Bytes(), v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.Code
+	}
+
+	// The trash is only sent with sync
+	var ciphs struct {
+		Data   []Cipher
+		Object string
+	}
+	code := get(handleCiphers, "/api/ciphers", &ciphs)
+	if code != 200 || ciphs.Object != "list" || len(ciphs.Data) != 1 || ciphs.Data[0].Id != ciph.Id {
+		t.Errorf("Expected a list with cipher %v got %v %+v", ciph.Id, code, ciphs)
+	}
+
+	for _, url := range []string{"/api/ciphers/" + ciph.Id, "/api/ciphers/" + ciph.Id + "/details"} {
+		var got Cipher
+		code = get(handleCipherUpdate, url, &got)
+		if code != 200 || got.Id != ciph.Id {
+			t.Errorf("Expected cipher %v from %v got %v %+v", ciph.Id, url, code, got)
+		}
+	}
+	code = get(handleCipherUpdate, "/api/ciphers/"+otherCiph.Id, &Cipher{})
+	if code != 404 {
+		t.Errorf("Expected 404 for a cipher of another account got %v", code)
+	}
+
+	var folders struct {
+		Data   []Folder
+		Object string
+	}
+	code = get(handleFolders, "/api/folders", &folders)
+	if code != 200 || folders.Object != "list" || len(folders.Data) != 1 || folders.Data[0].Id != folder.Id {
+		t.Errorf("Expected a list with folder %v got %v %+v", folder.Id, code, folders)
+	}
+
+	var got Folder
+	code = get(handleFolder, "/api/folders/"+folder.Id, &got)
+	if code != 200 || got.Id != folder.Id || got.Name != "name" {
+		t.Errorf("Expected folder %v got %v %+v", folder.Id, code, got)
+	}
+	code = get(handleFolder, "/api/folders/"+otherFolder.Id, &Folder{})
+	if code != 404 {
+		t.Errorf("Expected 404 for a folder of another account got %v", code)
+	}
+}
+
+func TestHandleFolder(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	other := newTestAccount(t, d, "other@example.com")
+
+	folder, err := d.addFolder(testEncString("folder"), acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciph, err := d.newCipher(Cipher{Type: 1, FolderId: &folder.Id}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folderReq := func(a Account, method string, url string, body string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handleFolder(res, testRequest(t, a, method, url, body))
+		return res
+	}
+	name := func() string {
+		f, err := d.getFolder(acc.Id, folder.Id)
+		if err != nil {
+			return ""
+		}
+		return f.Name
+	}
+
+	res := folderReq(acc, "PUT", "/api/folders/"+folder.Id, `{"name":"`+testEncString("renamed")+`"}`)
+	var renamed Folder
+	err = json.Unmarshal(res.Body.Bytes(), &renamed)
+	if res.Code != 200 || err != nil || renamed.Id != folder.Id || renamed.Name != testEncString("renamed") {
+		t.Errorf("Expected the renamed folder got %v %s", res.Code, res.Body.Bytes())
+	}
+
+	res = folderReq(acc, "PUT", "/api/folders/"+folder.Id, `{"name":"not encrypted"}`)
+	if res.Code != 400 || name() != testEncString("renamed") {
+		t.Errorf("Expected 400 for an unencrypted name got %v", res.Code)
+	}
+
+	// Folders of other accounts aren't found
+	res = folderReq(other, "PUT", "/api/folders/"+folder.Id, `{"name":"`+testEncString("other")+`"}`)
+	if res.Code != 404 || name() != testEncString("renamed") {
+		t.Errorf("Expected 404 renaming a folder of another account got %v", res.Code)
+	}
+	res = folderReq(other, "DELETE", "/api/folders/"+folder.Id, "")
+	if res.Code != 404 || name() == "" {
+		t.Errorf("Expected 404 deleting a folder of another account got %v", res.Code)
+	}
+
+	// The ciphers of a deleted folder are kept without one
+	res = folderReq(acc, "DELETE", "/api/folders/"+folder.Id, "")
+	if res.Code != 200 || name() != "" {
+		t.Errorf("Expected the folder to be deleted got %v", res.Code)
+	}
+	kept, err := d.getCipher(acc.Id, ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.FolderId != nil || kept.RevisionDate.Before(ciph.RevisionDate) {
+		t.Errorf("Expected the cipher without a folder and a new revision date got %+v", kept)
+	}
+
+	res = folderReq(acc, "POST", "/api/folders/"+folder.Id+"/delete", "")
+	if res.Code != 404 {
+		t.Errorf("Expected 404 deleting the folder again got %v", res.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// A small MessagePack encoder for the notifications hub. Only the types used
+// in hub messages are supported. Map keys are sorted so the output is stable.
+func msgpackAppend(b []byte, v interface{}) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+
+	case int:
+		return msgpackAppendInt(b, int64(v)), nil
+
+	case int64:
+		return msgpackAppendInt(b, v), nil
+
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			b = append(b, 0xa0|byte(n))
+		case n <= math.MaxUint8:
+			b = append(b, 0xd9, byte(n))
+		case n <= math.MaxUint16:
+			b = append(b, 0xda, byte(n>>8), byte(n))
+		default:
+			b = append(b, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		}
+		return append(b, v...), nil
+
+	case []interface{}:
+		b = msgpackAppendHeader(b, len(v), 0x90, 0xdc)
+		for _, e := range v {
+			b, err = msgpackAppend(b, e)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b = msgpackAppendHeader(b, len(v), 0x80, 0xde)
+		for _, k := range keys {
+			b, _ = msgpackAppend(b, k)
+			b, err = msgpackAppend(b, v[k])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+
+	case time.Time:
+		// Timestamp extension type -1 with nanoseconds and seconds
+		b = append(b, 0xc7, 12, 0xff)
+		var ts [12]byte
+		binary.BigEndian.PutUint32(ts[:4], uint32(v.Nanosecond()))
+		binary.BigEndian.PutUint64(ts[4:], uint64(v.Unix()))
+		return append(b, ts[:]...), nil
+	}
+
+	return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+}
+
+func msgpackAppendInt(b []byte, v int64) []byte {
+	if v >= -32 && v <= math.MaxInt8 {
+		return append(b, byte(v)) // Positive and negative fixint
+	}
+
+	var i [8]byte
+	binary.BigEndian.PutUint64(i[:], uint64(v))
+	return append(append(b, 0xd3), i[:]...)
+}
+
+// msgpackAppendHeader adds an array or map header. fix is the type byte for
+// less than 16 elements, long the one with a 16 bit length.
+func msgpackAppendHeader(b []byte, n int, fix byte, long byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, long, byte(n>>8), byte(n))
+	default:
+		return append(b, long+1, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// msgpackMessageType returns the first element of a SignalR message, which
+// is an array starting with the message type
+func msgpackMessageType(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, errors.New("msgpack: empty message")
+	}
+
+	switch {
+	case data[0]&0xf0 == 0x90:
+		data = data[1:]
+	case data[0] == 0xdc && len(data) >= 3:
+		data = data[3:]
+	case data[0] == 0xdd && len(data) >= 5:
+		data = data[5:]
+	default:
+		return 0, errors.New("msgpack: message isn't an array")
+	}
+
+	switch {
+	case len(data) >= 1 && data[0] <= math.MaxInt8:
+		return int(data[0]), nil
+	case len(data) >= 2 && data[0] == 0xcc:
+		return int(data[1]), nil
+	}
+	return 0, errors.New("msgpack: invalid message type")
+}
+
+// Binary SignalR messages are prefixed with their length as a varint
+func appendVarint(b []byte, n int) []byte {
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
+
+// splitVarintMessages returns the length prefixed messages in data
+func splitVarintMessages(data []byte) ([][]byte, error) {
+	var msgs [][]byte
+	for len(data) > 0 {
+		n, shift, i := 0, uint(0), 0
+		for {
+			if i >= len(data) || i >= 5 {
+				return nil, errors.New("invalid message length")
+			}
+			n |= int(data[i]&0x7f) << shift
+			shift += 7
+			i++
+			if data[i-1]&0x80 == 0 {
+				break
+			}
+		}
+
+		if n > len(data)-i {
+			return nil, errors.New("incomplete message")
+		}
+		msgs = append(msgs, data[i:i+n])
+		data = data[i+n:]
+	}
+	return msgs, nil
+}
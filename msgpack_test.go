@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMsgpackAppend(t *testing.T) {
+	cases := []struct {
+		v        interface{}
+		expected []byte
+	}{{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{5, []byte{0x05}},
+		{-1, []byte{0xff}},
+		{300, []byte{0xd3, 0, 0, 0, 0, 0, 0, 0x01, 0x2c}},
+		{"ab", []byte{0xa2, 'a', 'b'}},
+		{[]interface{}{1, nil}, []byte{0x92, 0x01, 0xc0}},
+		{map[string]interface{}{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{time.Unix(1, 2), []byte{0xc7, 12, 0xff, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1}},
+	}
+
+	for _, c := range cases {
+		b, err := msgpackAppend(nil, c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.expected) {
+			t.Errorf("Expected %x for %v got %x", c.expected, c.v, b)
+		}
+	}
+
+	if _, err := msgpackAppend(nil, 1.5); err == nil {
+		t.Error("Expected an error for an unsupported type")
+	}
+}
+
+func TestSplitVarintMessages(t *testing.T) {
+	long := bytes.Repeat([]byte{0x90}, 200)
+	data := append(appendVarint(nil, len(long)), long...)
+	data = append(data, 2, 0x91, hubClose)
+
+	msgs, err := splitVarintMessages(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || len(msgs[0]) != 200 {
+		t.Fatalf("Wrong messages %x", msgs)
+	}
+
+	typ, err := msgpackMessageType(msgs[1])
+	if err != nil || typ != hubClose {
+		t.Fatalf("Expected a close message got %v %v", typ, err)
+	}
+
+	if _, err := splitVarintMessages([]byte{5, 0x91}); err == nil {
+		t.Fatal("Expected an error for an incomplete message")
+	}
+}
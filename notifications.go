@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Types of the push notifications the clients understand
+const (
+	pushSyncCipherUpdate = 0
+	pushSyncCipherCreate = 1
+	pushSyncLoginDelete  = 2
+	pushSyncFolderDelete = 3
+	pushSyncCiphers      = 4
+	pushSyncVault        = 5
+	pushSyncOrgKeys      = 6
+	pushSyncFolderCreate = 7
+	pushSyncFolderUpdate = 8
+	pushSyncCipherDelete = 9
+	pushSyncSettings     = 10
+	pushLogOut           = 11
+)
+
+// SignalR message types
+const (
+	hubInvocation = 1
+	hubPing       = 6
+	hubClose      = 7
+)
+
+// Ends every message of the SignalR JSON protocol
+const recordSeparator = 0x1e
+
+// The server pings the clients so idle connections aren't closed by proxies
+const hubPingInterval = 15 * time.Second
+
+// A device connected to /notifications/hub
+type hubClient struct {
+	ws          *wsConn
+	messagePack bool        // Binary MessagePack protocol instead of JSON
+	send        chan []byte // Encoded messages waiting to be written
+}
+
+// The connected devices by account id
+type notificationHub struct {
+	sync.Mutex
+	clients map[string]map[*hubClient]bool
+}
+
+var hub = &notificationHub{clients: make(map[string]map[*hubClient]bool)}
+
+func (h *notificationHub) add(owner string, c *hubClient) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.clients[owner] == nil {
+		h.clients[owner] = make(map[*hubClient]bool)
+	}
+	h.clients[owner][c] = true
+}
+
+func (h *notificationHub) remove(owner string, c *hubClient) {
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.clients[owner][c] {
+		return
+	}
+	delete(h.clients[owner], c)
+	if len(h.clients[owner]) == 0 {
+		delete(h.clients, owner)
+	}
+	close(c.send)
+}
+
+// publish sends a notification to all devices of the account. contextID
+// identifies the device that made the change, which ignores it.
+func (h *notificationHub) publish(owner string, typ int, contextID string, payload map[string]interface{}) {
+	var context interface{}
+	if contextID != "" {
+		context = contextID
+	}
+	msg := map[string]interface{}{"ContextId": context, "Type": typ, "Payload": payload}
+
+	h.Lock()
+	defer h.Unlock()
+
+	for c := range h.clients[owner] {
+		data, err := c.encodeInvocation("ReceiveMessage", msg)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		select {
+		case c.send <- data:
+		default:
+			log.Println("Notification dropped for a slow client")
+		}
+	}
+}
+
+func (c *hubClient) encodeInvocation(target string, arg map[string]interface{}) ([]byte, error) {
+	if c.messagePack {
+		return encodeMessagePackMessage([]interface{}{hubInvocation, map[string]interface{}{}, nil, target, []interface{}{arg}})
+	}
+	return encodeJSONMessage(map[string]interface{}{"type": hubInvocation, "target": target, "arguments": []interface{}{arg}})
+}
+
+func (c *hubClient) encodePing() ([]byte, error) {
+	if c.messagePack {
+		return encodeMessagePackMessage([]interface{}{hubPing})
+	}
+	return encodeJSONMessage(map[string]interface{}{"type": hubPing})
+}
+
+func encodeJSONMessage(msg interface{}) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, recordSeparator), nil
+}
+
+func encodeMessagePackMessage(msg []interface{}) ([]byte, error) {
+	data, err := msgpackAppend(nil, msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendVarint(nil, len(data)), data...), nil
+}
+
+// writeLoop sends the queued messages and pings until the client is removed
+// from the hub. The connection is closed on errors, which ends readLoop.
+func (c *hubClient) writeLoop() {
+	ticker := time.NewTicker(hubPingInterval)
+	defer ticker.Stop()
+
+	frameType := wsText
+	if c.messagePack {
+		frameType = wsBinary
+	}
+
+	for {
+		var data []byte
+		var err error
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				return
+			}
+			data = msg
+		case <-ticker.C:
+			data, err = c.encodePing()
+		}
+
+		if err == nil {
+			err = c.ws.writeMessage(frameType, data)
+		}
+		if err != nil {
+			log.Println("Notifications: " + err.Error())
+			c.ws.close()
+			return
+		}
+	}
+}
+
+// readLoop reads the messages of the client until it closes the connection.
+// The clients don't invoke anything on the hub, so only close messages
+// matter.
+func (c *hubClient) readLoop(pending []byte) error {
+	data := pending
+	for {
+		types, err := c.messageTypes(data)
+		if err != nil {
+			return err
+		}
+		for _, typ := range types {
+			if typ == hubClose {
+				return nil
+			}
+		}
+
+		_, data, err = c.ws.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// messageTypes returns the type of each message in data
+func (c *hubClient) messageTypes(data []byte) ([]int, error) {
+	var types []int
+	if c.messagePack {
+		msgs, err := splitVarintMessages(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			typ, err := msgpackMessageType(msg)
+			if err != nil {
+				return nil, err
+			}
+			types = append(types, typ)
+		}
+		return types, nil
+	}
+
+	for _, msg := range bytes.Split(data, []byte{recordSeparator}) {
+		if len(msg) == 0 {
+			continue
+		}
+		var m struct {
+			Type int `json:"type"`
+		}
+		err := json.Unmarshal(msg, &m)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, m.Type)
+	}
+	return types, nil
+}
+
+// readHandshake reads the protocol the client wants to use. Returns
+// whatever was sent after the handshake in the same message.
+func (c *hubClient) readHandshake() ([]byte, error) {
+	_, data, err := c.ws.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	i := bytes.IndexByte(data, recordSeparator)
+	if i < 0 {
+		return nil, errors.New("incomplete handshake")
+	}
+
+	var handshake struct {
+		Protocol string `json:"protocol"`
+		Version  int    `json:"version"`
+	}
+	err = json.Unmarshal(data[:i], &handshake)
+	if err != nil {
+		return nil, err
+	}
+
+	switch handshake.Protocol {
+	case "json":
+	case "messagepack":
+		c.messagePack = true
+	default:
+		reply, _ := encodeJSONMessage(map[string]string{"error": "Protocol '" + handshake.Protocol + "' is not supported."})
+		c.ws.writeMessage(wsText, reply)
+		return nil, errors.New("unsupported protocol " + handshake.Protocol)
+	}
+
+	// The handshake response is always JSON
+	err = c.ws.writeMessage(wsText, []byte{'{', '}', recordSeparator})
+	if err != nil {
+		return nil, err
+	}
+
+	return data[i+1:], nil
+}
+
+// This function handles /notifications/hub. Browsers can't set headers on
+// WebSockets, so the access token can also be sent in the query string.
+func handleNotificationHub(w http.ResponseWriter, req *http.Request) {
+	tokenString := req.URL.Query().Get("access_token")
+	if tokenString == "" {
+		tokenString = bearerToken(req)
+	}
+
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		log.Println("JWT: " + err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(401)))
+		return
+	}
+	email := claims["email"].(string)
+
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(401)))
+		log.Println(err)
+		return
+	}
+
+	// Tokens from before the subject was the account id. The clients compare
+	// the notifications with it, so they have to log in again.
+	if sub, _ := claims["sub"].(string); sub != acc.Id {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(401)))
+		log.Println(email + " connected to notifications with an old token")
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, req)
+	if err != nil {
+		log.Println("Notifications: " + err.Error())
+		return
+	}
+	defer ws.close()
+
+	c := &hubClient{ws: ws, send: make(chan []byte, 16)}
+	pending, err := c.readHandshake()
+	if err != nil {
+		log.Println("Notifications: " + err.Error())
+		return
+	}
+
+	log.Println(email + " connected to notifications")
+	hub.add(acc.Id, c)
+	go c.writeLoop()
+
+	err = c.readLoop(pending)
+	if err != nil {
+		log.Println("Notifications: " + err.Error())
+	}
+
+	hub.remove(acc.Id, c)
+	log.Println(email + " disconnected from notifications")
+}
+
+// This function handles /notifications/hub/negotiate, which clients that
+// don't skip negotiation call before connecting
+func handleNotificationNegotiate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+
+	id := uuid.NewV4().String()
+	data, err := json.Marshal(map[string]interface{}{
+		"connectionId":     id,
+		"connectionToken":  id,
+		"negotiateVersion": 1,
+		"availableTransports": []map[string]interface{}{
+			{"transport": "WebSockets", "transferFormats": []string{"Text", "Binary"}},
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// pushContext returns the device that made the request
+func pushContext(req *http.Request) string {
+	if device, _ := req.Context().Value(ctxKey("device")).(string); device != "" {
+		return device
+	}
+	return req.Header.Get("Device-Identifier")
+}
+
+// pushCipher tells the other devices of the owner that a cipher changed. The
+// cipher is looked up, because the request may have used its legacy id and
+// the clients only know the UUID. A deleted cipher has to be sent with its
+// UUID already.
+func pushCipher(req *http.Request, typ int, owner string, ciphID string) {
+	revDate := time.Now()
+	if typ != pushSyncCipherDelete {
+		ciph, err := db.getCipher(owner, ciphID)
+		if err != nil {
+			log.Println("Notifications: " + err.Error())
+			return
+		}
+		ciphID, revDate = ciph.Id, ciph.RevisionDate
+	}
+
+	hub.publish(owner, typ, pushContext(req), map[string]interface{}{
+		"Id":             ciphID,
+		"UserId":         owner,
+		"OrganizationId": nil,
+		"CollectionIds":  nil,
+		"RevisionDate":   revDate,
+	})
+}
+
+// pushFolder tells the other devices of the owner that a folder changed
+func pushFolder(req *http.Request, typ int, owner string, folderID string) {
+	hub.publish(owner, typ, pushContext(req), map[string]interface{}{
+		"Id":           folderID,
+		"UserId":       owner,
+		"RevisionDate": time.Now(),
+	})
+}
+
+// pushUser sends a notification about the whole account, like
+// pushSyncVault or pushLogOut
+func pushUser(req *http.Request, typ int, owner string) {
+	hub.publish(owner, typ, pushContext(req), map[string]interface{}{
+		"UserId": owner,
+		"Date":   time.Now(),
+	})
+}
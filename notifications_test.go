@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const hubTestAccount = "7f6a4d2e-1c9b-4f3e-9d8a-2b5c6e7f8a9b"
+
+// wsClientFrame returns a frame masked like the clients have to send them
+func wsClientFrame(fin bool, opcode int, payload []byte) []byte {
+	head := byte(opcode)
+	if fin {
+		head |= 0x80
+	}
+
+	frame := []byte{head}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	}
+
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// readServerFrame reads one unmasked frame sent by the server
+func readServerFrame(t *testing.T, r *bufio.Reader) (int, []byte) {
+	var head [2]byte
+	_, err := io.ReadFull(r, head[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int(head[1] & 0x7f)
+	if size == 126 {
+		var b [2]byte
+		_, err = io.ReadFull(r, b[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		size = int(binary.BigEndian.Uint16(b[:]))
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(head[0] & 0x0f), payload
+}
+
+// dialHub opens a WebSocket to the hub and sends the SignalR handshake for
+// the protocol, split over two frames. Returns the handshake response.
+func dialHub(t *testing.T, server *httptest.Server, token string, protocol string) (net.Conn, *bufio.Reader, []byte) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The key and accept value are the example of RFC 6455
+	io.WriteString(conn, "GET /notifications/hub?access_token="+token+" HTTP/1.1\r\n"+
+		"Host: localhost\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Expected a WebSocket upgrade got %v %v", res.StatusCode, res.Header)
+	}
+
+	conn.Write(wsClientFrame(false, wsText, []byte(`{"protocol":"`+protocol+`",`)))
+	conn.Write(wsClientFrame(true, wsContinuation, []byte(`"version":1}`+"\x1e")))
+
+	opcode, data := readServerFrame(t, r)
+	if opcode != wsText {
+		t.Fatalf("Expected a text frame for the handshake got %v", opcode)
+	}
+	return conn, r, data
+}
+
+// waitForHubClients waits until the hub has n clients for the owner
+func waitForHubClients(t *testing.T, owner string, n int) {
+	for i := 0; i < 100; i++ {
+		hub.Lock()
+		count := len(hub.clients[owner])
+		hub.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %v hub clients", n)
+}
+
+func hubTestToken(t *testing.T, sub string) string {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["sub"] = sub
+	claims["email"] = "nobody@example.com"
+	claims["sstamp"] = ""
+	tokenString, err := token.SignedString(mySigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
+func TestNotificationHub(t *testing.T) {
+	db = &mockDB{id: hubTestAccount, username: "nobody@example.com"}
+	server := httptest.NewServer(http.HandlerFunc(handleNotificationHub))
+	defer server.Close()
+	token := hubTestToken(t, hubTestAccount)
+
+	// Tokens from before the subject was the account id can't be used
+	for _, invalid := range []string{"invalid", hubTestToken(t, "NA")} {
+		res, err := http.Get(server.URL + "/notifications/hub?access_token=" + invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for the token %v got %v", invalid, res.StatusCode)
+		}
+	}
+
+	for _, protocol := range []string{"json", "messagepack"} {
+		conn, r, handshake := dialHub(t, server, token, protocol)
+		if string(handshake) != "{}\x1e" {
+			t.Fatalf("Expected an empty handshake response for %v got %q", protocol, handshake)
+		}
+		waitForHubClients(t, hubTestAccount, 1)
+
+		conn.Write(wsClientFrame(true, wsPing, []byte("ping")))
+		opcode, data := readServerFrame(t, r)
+		if opcode != wsPong || string(data) != "ping" {
+			t.Errorf("Expected a pong for %v got %v %q", protocol, opcode, data)
+		}
+
+		hub.publish(hubTestAccount, pushSyncFolderCreate, "device", map[string]interface{}{"Id": "folder", "UserId": hubTestAccount})
+		opcode, data = readServerFrame(t, r)
+
+		var closeMsg []byte
+		if protocol == "json" {
+			var msg struct {
+				Type      int    `json:"type"`
+				Target    string `json:"target"`
+				Arguments []struct {
+					ContextId string
+					Type      int
+					Payload   map[string]string
+				} `json:"arguments"`
+			}
+			err := json.Unmarshal(bytes.TrimSuffix(data, []byte{recordSeparator}), &msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opcode != wsText || msg.Type != hubInvocation || msg.Target != "ReceiveMessage" || len(msg.Arguments) != 1 ||
+				msg.Arguments[0].ContextId != "device" || msg.Arguments[0].Type != pushSyncFolderCreate || msg.Arguments[0].Payload["Id"] != "folder" {
+				t.Errorf("Wrong notification %v %s", opcode, data)
+			}
+			closeMsg = []byte(`{"type":7}` + "\x1e")
+		} else {
+			msgs, err := splitVarintMessages(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opcode != wsBinary || len(msgs) != 1 || !bytes.Contains(msgs[0], []byte("ReceiveMessage")) || !bytes.Contains(msgs[0], []byte("folder")) {
+				t.Fatalf("Wrong notification %v %x", opcode, data)
+			}
+			typ, err := msgpackMessageType(msgs[0])
+			if err != nil || typ != hubInvocation {
+				t.Errorf("Expected an invocation got %v %v", typ, err)
+			}
+			closeMsg = append(appendVarint(nil, 2), 0x91, hubClose)
+		}
+
+		// The client is removed after a close message
+		frameType := wsText
+		if protocol == "messagepack" {
+			frameType = wsBinary
+		}
+		conn.Write(wsClientFrame(true, frameType, closeMsg))
+		waitForHubClients(t, hubTestAccount, 0)
+		conn.Close()
+	}
+
+	conn, r, reply := dialHub(t, server, token, "xml")
+	defer conn.Close()
+	if !strings.Contains(string(reply), "not supported") {
+		t.Errorf("Expected an error for an unknown protocol got %q", reply)
+	}
+
+	// The connection is closed after the error
+	_, err := r.ReadByte()
+	if err != io.EOF {
+		t.Errorf("Expected the connection to be closed got %v", err)
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	db = &mockDB{id: hubTestAccount, username: "nobody@example.com"}
+	server := httptest.NewServer(http.HandlerFunc(handleNotificationHub))
+	defer server.Close()
+
+	conn, r, _ := dialHub(t, server, hubTestToken(t, hubTestAccount), "json")
+	defer conn.Close()
+	waitForHubClients(t, hubTestAccount, 1)
+
+	// A closing handshake of the WebSocket itself is answered
+	conn.Write(wsClientFrame(true, wsClose, nil))
+	opcode, _ := readServerFrame(t, r)
+	if opcode != wsClose {
+		t.Errorf("Expected a close frame got %v", opcode)
+	}
+	waitForHubClients(t, hubTestAccount, 0)
+}
+
+// Clients that haven't synced since the migration still use the old ids
+func TestPushCipherLegacyID(t *testing.T) {
+	d, cleanup := openBaselineDB(t)
+	defer cleanup()
+	old := db
+	db = d
+	defer func() { db = old }()
+
+	acc, err := d.getAccount("nobody@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciph, err := d.getCipher(acc.Id, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &hubClient{send: make(chan []byte, 1)}
+	hub.add(acc.Id, c)
+	defer hub.remove(acc.Id, c)
+
+	req := httptest.NewRequest("PUT", "/api/ciphers/1", nil)
+	pushCipher(req, pushSyncCipherUpdate, acc.Id, "1")
+
+	var msg struct {
+		Arguments []struct {
+			Payload struct {
+				Id           string
+				RevisionDate time.Time
+			}
+		} `json:"arguments"`
+	}
+	select {
+	case m := <-c.send:
+		err = json.Unmarshal(bytes.TrimSuffix(m, []byte{recordSeparator}), &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("Expected a notification")
+	}
+
+	if len(msg.Arguments) != 1 || msg.Arguments[0].Payload.Id != ciph.Id || !msg.Arguments[0].Payload.RevisionDate.Equal(ciph.RevisionDate) {
+		t.Errorf("Expected the UUID %v from %v got %+v", ciph.Id, ciph.RevisionDate, msg)
+	}
+}
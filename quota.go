@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"math"
+)
+
+// quotaLimit converts a -quota-* flag, where a negative value means the default
+func quotaLimit(v int64) *int64 {
+	if v < 0 {
+		return nil
+	}
+	return &v
+}
+
+// setAccountQuota changes the storage limits of an account that aren't nil
+// and logs them with what it uses now. The values are like the -quota-*
+// flags.
+func setAccountQuota(email string, items *int64, dataBytes *int64, attachmentBytes *int64) error {
+	acc, err := db.getAccount(email, "")
+	if err != nil {
+		return err
+	}
+
+	if items != nil {
+		acc.Quota.Items = quotaLimit(*items)
+	}
+	if dataBytes != nil {
+		acc.Quota.DataBytes = quotaLimit(*dataBytes)
+	}
+	if attachmentBytes != nil {
+		acc.Quota.AttachmentBytes = quotaLimit(*attachmentBytes)
+	}
+
+	err = db.setQuota(email, acc.Quota)
+	if err != nil {
+		return err
+	}
+
+	used, err := db.getUsage(acc.Id)
+	if err != nil {
+		return err
+	}
+
+	limits := acc.Quota.limits()
+	log.Printf("%s uses %d of %d items, %d of %d bytes of data and %d of %d bytes of attachments (0 is unlimited)\n", email,
+		used.Items, limits.Items, used.DataBytes, limits.DataBytes, used.AttachmentBytes, limits.AttachmentBytes)
+	return nil
+}
+
+// setProfileStorage fills in the attachment storage the account uses and may use
+func setProfileStorage(prof *Profile, acc Account) {
+	used, err := db.getUsage(acc.Id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	prof.StorageName = sizeName(used.AttachmentBytes)
+	prof.StorageGb = math.Floor(float64(used.AttachmentBytes)/(1<<30)*100+0.5) / 100
+
+	if limit := acc.Quota.limits().AttachmentBytes; limit > 0 {
+		gb := int(math.Ceil(float64(limit) / (1 << 30)))
+		prof.MaxStorageGb = &gb
+	}
+}
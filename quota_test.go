@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetAccountQuota(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+
+	quota := func() Quota {
+		acc, err := d.getAccount(acc.Email, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return acc.Quota
+	}
+	limit := func(v int64) *int64 {
+		return &v
+	}
+
+	err := setAccountQuota(acc.Email, limit(5), limit(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := quota()
+	if q.Items == nil || *q.Items != 5 || q.DataBytes == nil || *q.DataBytes != 0 || q.AttachmentBytes != nil {
+		t.Errorf("Expected 5 items and unlimited data got %+v", q)
+	}
+
+	// Limits that aren't given stay as they are, -1 resets them
+	err = setAccountQuota(acc.Email, nil, limit(-1), limit(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q = quota()
+	if q.Items == nil || *q.Items != 5 || q.DataBytes != nil || q.AttachmentBytes == nil || *q.AttachmentBytes != 100 {
+		t.Errorf("Expected 5 items, the default data and 100 bytes of attachments got %+v", q)
+	}
+
+	err = setAccountQuota("other@example.com", limit(5), nil, nil)
+	if err == nil {
+		t.Error("Expected an error for an unknown account")
+	}
+}
+
+func TestQuotaEnforcement(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+	_, cleanupBlobs := useTestBlobStore(t)
+	defer cleanupBlobs()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	setQuota := func(q Quota) {
+		err := d.setQuota(acc.Email, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	limit := func(v int64) *int64 {
+		return &v
+	}
+	exceeded := func(res *httptest.ResponseRecorder) bool {
+		return res.Code == 400 && strings.Contains(res.Body.String(), errQuotaExceeded.Error())
+	}
+	cipher := func(name string) string {
+		return `{"type":1,"name":"` + testEncString(name) + `","login":{}}`
+	}
+
+	setQuota(Quota{Items: limit(1)})
+
+	res := httptest.NewRecorder()
+	handleNewCipher(res, testRequest(t, acc, "POST", "/api/ciphers", cipher("first")))
+	if res.Code != 200 {
+		t.Fatalf("Expected 200 for the first cipher got %v", res.Code)
+	}
+	var ciph Cipher
+	err := json.Unmarshal(res.Body.Bytes(), &ciph)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = httptest.NewRecorder()
+	handleNewCipher(res, testRequest(t, acc, "POST", "/api/ciphers", cipher("second")))
+	if !exceeded(res) {
+		t.Errorf("Expected the item quota to be exceeded got %v %s", res.Code, res.Body.Bytes())
+	}
+
+	res = httptest.NewRecorder()
+	handleImport(res, testRequest(t, acc, "POST", "/api/ciphers/import", `{"folders":[],"ciphers":[`+cipher("imported")+`],"folderRelationships":[]}`), acc)
+	if !exceeded(res) {
+		t.Errorf("Expected the item quota to be exceeded by an import got %v %s", res.Code, res.Body.Bytes())
+	}
+
+	// Updates may not grow the data past the quota
+	used, err := d.getUsage(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setQuota(Quota{DataBytes: limit(used.DataBytes)})
+
+	res = httptest.NewRecorder()
+	handleCipherUpdate(res, testRequest(t, acc, "PUT", "/api/ciphers/"+ciph.Id, `{"type":1,"name":"`+testEncString("first")+`","notes":"`+testEncString("notes")+`","login":{}}`))
+	if !exceeded(res) {
+		t.Errorf("Expected the data quota to be exceeded by an update got %v %s", res.Code, res.Body.Bytes())
+	}
+
+	setQuota(Quota{AttachmentBytes: limit(10)})
+
+	body, mw := attachmentForm(t, attachmentNames[0])
+	req := testRequest(t, acc, "POST", "/api/ciphers/"+ciph.Id+"/attachment", "")
+	req.Body = ioutil.NopCloser(body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res = httptest.NewRecorder()
+	handleNewAttachment(res, req, acc, ciph.Id)
+	if !exceeded(res) {
+		t.Errorf("Expected the attachment quota to be exceeded got %v %s", res.Code, res.Body.Bytes())
+	}
+
+	ciphs, err := d.getCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 1 || ciphs[0].Data.Notes != nil || len(ciphs[0].Attachments) != 0 {
+		t.Errorf("Expected only the first cipher unchanged got %+v", ciphs)
+	}
+}
+
+func TestProfileStorage(t *testing.T) {
+	d, cleanup := useTestDB(t)
+	defer cleanup()
+
+	acc := newTestAccount(t, d, "nobody@example.com")
+	ciph, err := d.newCipher(Cipher{Type: 1}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.addAttachment(Attachment{Id: "attachment", CipherId: ciph.Id, Size: "1536"}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := func() Profile {
+		res := httptest.NewRecorder()
+		handleSync(res, testRequest(t, acc, "GET", "/api/sync", ""))
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected 200 got %v", res.Code)
+		}
+
+		var data SyncData
+		err := json.Unmarshal(res.Body.Bytes(), &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data.Profile
+	}
+
+	prof := profile()
+	if prof.StorageName != "1.5 KB" || prof.MaxStorageGb == nil || *prof.MaxStorageGb != 1 {
+		t.Errorf("Expected 1.5 KB of 1 GB got %v of %v", prof.StorageName, prof.MaxStorageGb)
+	}
+
+	unlimited := int64(0)
+	err = d.setQuota(acc.Email, Quota{AttachmentBytes: &unlimited})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof = profile()
+	if prof.MaxStorageGb != nil {
+		t.Errorf("Expected no limit got %v", *prof.MaxStorageGb)
+	}
+}
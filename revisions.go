@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// Device types the clients send in the Device-Type header
+var deviceTypes = map[string]string{
+	"0":  "Android",
+	"1":  "iOS",
+	"2":  "Chrome Extension",
+	"3":  "Firefox Extension",
+	"4":  "Opera Extension",
+	"5":  "Edge Extension",
+	"6":  "Windows",
+	"7":  "macOS",
+	"8":  "Linux",
+	"9":  "Chrome",
+	"10": "Firefox",
+	"11": "Opera",
+	"12": "Edge",
+	"13": "Internet Explorer",
+	"14": "Unknown Browser",
+	"15": "Android",
+	"16": "UWP",
+	"17": "Safari",
+	"18": "Vivaldi",
+	"19": "Vivaldi Extension",
+	"20": "Safari Extension",
+}
+
+// deviceName returns a readable name for the device that sent the request
+func deviceName(req *http.Request) string {
+	if name, ok := deviceTypes[req.Header.Get("Device-Type")]; ok {
+		return name
+	}
+	return req.Header.Get("User-Agent")
+}
+
+// This function handles /api/ciphers/{id}/revisions and /api/ciphers/{id}/revisions/{revisionId}/restore
+func handleCipherRevisions(w http.ResponseWriter, req *http.Request, acc Account, ciphID string, revID string) {
+	switch {
+	case req.Method == "GET" && revID == "":
+		revisions, err := db.getCipherRevisions(acc.Id, ciphID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+
+		writeList(w, revisions)
+		return
+
+	case (req.Method == "POST" || req.Method == "PUT") && revID != "":
+		err := db.restoreCipherRevision(acc.Id, ciphID, revID, deviceName(req))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(404)))
+			log.Println(err)
+			return
+		}
+
+		pushCipher(req, pushSyncCipherUpdate, acc.Id, ciphID)
+
+		log.Println("Cipher " + ciphID + " restored to revision " + revID)
+		writeCipher(w, acc, ciphID)
+		return
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(405)))
+		return
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// handleCipherTrash moves a cipher to the trash or restores it from there
+func handleCipherTrash(w http.ResponseWriter, req *http.Request, acc Account, ciphID string, trash bool) {
+	var err error
+	if trash {
+		err = db.trashCiphers(acc.Id, []string{ciphID})
+	} else {
+		err = db.restoreCiphers(acc.Id, []string{ciphID})
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(404)))
+		log.Println(err)
+		return
+	}
+	pushCipher(req, pushSyncCipherUpdate, acc.Id, ciphID)
+
+	if trash {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(""))
+		log.Println("Cipher " + ciphID + " moved to trash")
+		return
+	}
+
+	log.Println("Cipher " + ciphID + " restored")
+	writeCipher(w, acc, ciphID)
+}
+
+// purgeTrash permanently deletes ciphers that have been in the trash for
+// longer than trashRetentionDays. Runs until the server stops.
+func purgeTrash() {
+	if trashRetentionDays <= 0 {
+		return
+	}
+
+	for {
+		before := time.Now().AddDate(0, 0, -trashRetentionDays)
+		attachments, err := db.purgeCiphers(before)
+		if err != nil {
+			log.Println("Purging trash " + err.Error())
+		}
+		removeAttachmentBlobs(attachments)
+
+		time.Sleep(time.Hour)
+	}
+}
@@ -2,18 +2,62 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"time"
 )
 
 type Account struct {
-	Id                 string `json:"-"`
-	Name               string `json:"name"`
-	Email              string `json:"email"`
-	MasterPasswordHash string `json:"masterPasswordHash"`
-	MasterPasswordHint string `json:"masterPasswordHint"`
-	Key                string `json:"key"`
-	RefreshToken       string `json:"-"`
+	Id                 string         `json:"-"`
+	Name               string         `json:"name"`
+	Email              string         `json:"email"`
+	MasterPasswordHash string         `json:"masterPasswordHash"`
+	MasterPasswordHint string         `json:"masterPasswordHint"`
+	Key                string         `json:"key"`
+	PrivateKey         string         `json:"privateKey"`
+	RefreshToken       string         `json:"-"`
+	SecurityStamp      string         `json:"-"` // Changes when the old sessions should be logged out
+	RevisionDate       time.Time      `json:"-"` // Last change of anything in the vault
+	Quota              Quota          `json:"quota"`
+	Domains            DomainSettings `json:"domains"`
+}
+
+// The equivalent domains set by the user. Logins for one of the domains in
+// a group are suggested for all of them.
+type DomainSettings struct {
+	EquivalentDomains               [][]string `json:"equivalentDomains"`
+	ExcludedGlobalEquivalentDomains []int      `json:"excludedGlobalEquivalentDomains"` // Types of the global groups not to use
+}
+
+// Storage limits of an account. nil means the default from the config, 0 unlimited.
+type Quota struct {
+	Items           *int64
+	DataBytes       *int64
+	AttachmentBytes *int64
+}
+
+// How much an account stores
+type Usage struct {
+	Items           int64
+	DataBytes       int64 // Size of the encrypted cipher data
+	AttachmentBytes int64
+}
+
+// limits returns the quota with the defaults filled in
+func (q Quota) limits() Usage {
+	l := Usage{Items: defaultQuotaItems, DataBytes: defaultQuotaDataBytes, AttachmentBytes: defaultQuotaAttachmentBytes}
+	if q.Items != nil {
+		l.Items = *q.Items
+	}
+	if q.DataBytes != nil {
+		l.DataBytes = *q.DataBytes
+	}
+	if q.AttachmentBytes != nil {
+		l.AttachmentBytes = *q.AttachmentBytes
+	}
+	return l
 }
 
 // The data we store and send to the client
@@ -22,23 +66,122 @@ type Cipher struct {
 	FolderId            *string // Must be pointer to output null in json. Android app will crash if not null
 	OrganizationId      *string
 	Favorite            bool
+	Reprompt            int // One of the CipherReprompt constants
 	Edit                bool
 	Id                  string
 	Data                CipherData
-	Attachments         []string
+	Attachments         []Attachment
+	PasswordHistory     []PasswordHistory
 	OrganizationUseTotp bool
 	RevisionDate        time.Time
+	DeletedDate         *time.Time // Set when the cipher is in the trash
 	Object              string
+	Device              string `json:"-"` // The device that made the last change
+
+	// Set when updating, the update fails if the cipher has changed since
+	LastKnownRevisionDate *time.Time `json:"-"`
+}
+
+// A previous version of a cipher
+type CipherRevision struct {
+	Id           string
+	CipherId     string
+	Type         int
+	Data         CipherData
+	RevisionDate time.Time
+	Device       string
+	Object       string
 }
 
 type CipherData struct {
-	Uri      string
+	Uri      string // First entry of Uris, kept for older clients
+	Uris     []CipherURI
 	Username string
 	Password string
 	Totp     *string // Must be pointer to output null in json. Android app will crash if not null
 	Name     string
 	Notes    *string // Must be pointer to output null in json. Android app will crash if not null
 	Fields   []string
+
+	PasswordRevisionDate *time.Time
+	Fido2Credentials     []Fido2Credential // Passkeys of the login
+
+	SshKey *SshKey // Only set for CipherTypeSshKey
+}
+
+// An SSH key pair, encrypted by the client
+type SshKey struct {
+	PrivateKey     string
+	PublicKey      string
+	KeyFingerprint string
+}
+
+// A passkey. Everything except the creation date is encrypted by the client
+type Fido2Credential struct {
+	CredentialId    string
+	KeyType         string
+	KeyAlgorithm    string
+	KeyCurve        string
+	KeyValue        string
+	RpId            string
+	RpName          *string
+	UserHandle      *string
+	UserName        *string
+	UserDisplayName *string
+	Counter         string
+	Discoverable    string
+	CreationDate    time.Time
+}
+
+// How a client should match a saved URI against the current page
+const (
+	UriMatchDomain = iota
+	UriMatchHost
+	UriMatchStartsWith
+	UriMatchExact
+	UriMatchRegularExpression
+	UriMatchNever
+)
+
+// The kinds of ciphers
+const (
+	CipherTypeLogin = 1 + iota
+	CipherTypeSecureNote
+	CipherTypeCard
+	CipherTypeIdentity
+	CipherTypeSshKey
+)
+
+// Whether the client should ask for the master password before showing the cipher
+const (
+	CipherRepromptNone = iota
+	CipherRepromptPassword
+)
+
+type CipherURI struct {
+	Uri   string
+	Match *int // Must be pointer to output null in json. null means the client default
+}
+
+// An old password of the login, encrypted by the client
+type PasswordHistory struct {
+	Password     string
+	LastUsedDate time.Time
+}
+
+// trimPasswordHistory keeps the newest maxPasswordHistory entries
+func (ciph *Cipher) trimPasswordHistory() {
+	sort.SliceStable(ciph.PasswordHistory, func(i, j int) bool {
+		return ciph.PasswordHistory[i].LastUsedDate.After(ciph.PasswordHistory[j].LastUsedDate)
+	})
+	if len(ciph.PasswordHistory) > maxPasswordHistory {
+		ciph.PasswordHistory = ciph.PasswordHistory[:maxPasswordHistory]
+	}
+}
+
+func (ciph *Cipher) passwordHistoryBytes() ([]byte, error) {
+	ciph.trimPasswordHistory()
+	return json.Marshal(&ciph.PasswordHistory)
 }
 
 func (data *CipherData) bytes() ([]byte, error) {
@@ -57,17 +200,65 @@ func unmarshalCipher(data io.ReadCloser) (Cipher, error) {
 
 	defer data.Close()
 
+	return nciph.toCipher()
+}
+
+// toCipher converts the data we got from the client to a Cipher
+func (nciph *newCipher) toCipher() (Cipher, error) {
+	uris, err := cipherURIs(nciph.Login)
+	if err != nil {
+		return Cipher{}, err
+	}
+
+	if nciph.Reprompt < CipherRepromptNone || nciph.Reprompt > CipherRepromptPassword {
+		return Cipher{}, fmt.Errorf("invalid reprompt type %d", nciph.Reprompt)
+	}
+
 	// Create new
 	cdata := CipherData{
 		Uri:      nciph.Login.URI,
+		Uris:     uris,
 		Username: nciph.Login.Username,
 		Password: nciph.Login.Password,
-		Totp:     nil,
+		Totp:     nullString(nciph.Login.ToTp),
 		Name:     nciph.Name,
 		Notes:    new(string),
 		Fields:   nil,
 	}
 
+	cdata.PasswordRevisionDate = nciph.Login.PasswordRevisionDate
+
+	for _, f := range nciph.Login.Fido2Credentials {
+		cdata.Fido2Credentials = append(cdata.Fido2Credentials, Fido2Credential{
+			CredentialId:    f.CredentialId,
+			KeyType:         f.KeyType,
+			KeyAlgorithm:    f.KeyAlgorithm,
+			KeyCurve:        f.KeyCurve,
+			KeyValue:        f.KeyValue,
+			RpId:            f.RpId,
+			RpName:          nullString(f.RpName),
+			UserHandle:      nullString(f.UserHandle),
+			UserName:        nullString(f.UserName),
+			UserDisplayName: nullString(f.UserDisplayName),
+			Counter:         f.Counter,
+			Discoverable:    f.Discoverable,
+			CreationDate:    f.CreationDate,
+		})
+	}
+
+	// Older clients only know about the single uri
+	if cdata.Uri == "" && len(uris) > 0 {
+		cdata.Uri = uris[0].Uri
+	}
+
+	if nciph.SshKey != nil && nciph.Type == CipherTypeSshKey {
+		cdata.SshKey = &SshKey{
+			PrivateKey:     nciph.SshKey.PrivateKey,
+			PublicKey:      nciph.SshKey.PublicKey,
+			KeyFingerprint: nciph.SshKey.KeyFingerprint,
+		}
+	}
+
 	(*cdata.Notes) = nciph.Notes
 
 	if *cdata.Notes == "" {
@@ -75,13 +266,81 @@ func unmarshalCipher(data io.ReadCloser) (Cipher, error) {
 	}
 
 	ciph := Cipher{ // Only including the data we use when we store it
-		Type: nciph.Type,
-		Data: cdata,
+		Id:             nciph.Id,
+		Type:           nciph.Type,
+		FolderId:       nullString(nciph.FolderId),
+		OrganizationId: nullString(nciph.OrganizationId),
+		Favorite:       nciph.Favorite,
+		Reprompt:       nciph.Reprompt,
+		Data:           cdata,
+
+		LastKnownRevisionDate: nciph.LastKnownRevisionDate,
 	}
 
+	for _, h := range nciph.PasswordHistory {
+		ciph.PasswordHistory = append(ciph.PasswordHistory, PasswordHistory{Password: h.Password, LastUsedDate: h.LastUsedDate})
+	}
+	ciph.trimPasswordHistory()
+
+	for id, a := range nciph.Attachments2 {
+		ciph.Attachments = append(ciph.Attachments, Attachment{Id: id, FileName: a.FileName, Key: a.Key, Object: "attachment"})
+	}
+	sort.Slice(ciph.Attachments, func(i, j int) bool { return ciph.Attachments[i].Id < ciph.Attachments[j].Id })
+
 	return ciph, nil
 }
 
+// nullString returns nil for an empty string so it's sent as null in json
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// cipherURIs builds the uri list from the login. Older clients only send the
+// single uri, in that case it becomes the only entry of the list.
+func cipherURIs(login loginData) ([]CipherURI, error) {
+	if len(login.URIs) == 0 {
+		if login.URI == "" {
+			return nil, nil
+		}
+		return []CipherURI{CipherURI{Uri: login.URI}}, nil
+	}
+
+	uris := make([]CipherURI, 0, len(login.URIs))
+	for _, u := range login.URIs {
+		if u.Match != nil && (*u.Match < UriMatchDomain || *u.Match > UriMatchNever) {
+			return nil, fmt.Errorf("invalid uri match type %d", *u.Match)
+		}
+		uris = append(uris, CipherURI{Uri: u.URI, Match: u.Match})
+	}
+	return uris, nil
+}
+
+type Attachment struct {
+	Id       string
+	CipherId string `json:"-"`
+	Url      string
+	FileName string // Encrypted by the client
+	Key      string // The file key, encrypted with the users key
+	Size     string
+	SizeName string
+	Object   string
+}
+
+// sizeName formats a file size the way the clients display it
+func sizeName(size int64) string {
+	units := []string{"Bytes", "KB", "MB", "GB", "TB"}
+	s := float64(size)
+	i := 0
+	for s >= 1024 && i < len(units)-1 {
+		s /= 1024
+		i++
+	}
+	return strconv.FormatFloat(float64(int64(s*100+0.5))/100, 'f', -1, 64) + " " + units[i]
+}
+
 type Profile struct {
 	Id                 string
 	Name               string
@@ -94,6 +353,10 @@ type Profile struct {
 	Key                string
 	PrivateKey         string
 	SecurityStamp      string
+	MaxStorageGb       *int // null if unlimited
+	StorageName        string
+	StorageGb          float64
+	RevisionDate       time.Time
 	Organizations      []string
 	Object             string
 }
@@ -107,8 +370,9 @@ type SyncData struct {
 }
 
 type Domains struct {
-	EquivalentDomains       []string
+	EquivalentDomains       [][]string
 	GlobalEquivalentDomains []GlobalEquivalentDomains
+	Object                  string
 }
 
 type GlobalEquivalentDomains struct {
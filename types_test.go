@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"testing"
 )
@@ -24,3 +25,201 @@ func TestUnmarshalCipher(t *testing.T) {
 		t.Fatal("Wrong type")
 	}
 }
+
+func TestUnmarshalCipherURIs(t *testing.T) {
+	testData := "{\"type\": 1,\"name\": \"2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=\",\"login\": {\"uri\": null,\"uris\": [{\"uri\": \"2.T57BwAuV8ubIn/sZPbQC+A==|EhUSSpJWSzSYOdJ/AQzfXuUXxwzcs/6C4tOXqhWAqcM=|OWV2VIqLfoWPs9DiouXGUOtTEkVeklbtJQHkQFIXkC8=\",\"match\": 3},{\"uri\": \"2.JbFkAEZPnuMm70cdP44wtA==|fsN6nbT+udGmOWv8K4otgw==|JbtwmNQa7/48KszT2hAdxpmJ6DRPZst0EDEZx5GzesI=\",\"match\": null}]}}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	Ci, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if len(Ci.Data.Uris) != 2 {
+		t.Fatalf("Expected 2 uris got %d", len(Ci.Data.Uris))
+	}
+
+	if Ci.Data.Uris[0].Match == nil || *Ci.Data.Uris[0].Match != UriMatchExact {
+		t.Fatal("Wrong match type for first uri")
+	}
+
+	if Ci.Data.Uris[1].Match != nil {
+		t.Fatal("Match should be nil when the client sends null")
+	}
+
+	if Ci.Data.Uri != Ci.Data.Uris[0].Uri {
+		t.Fatal("Legacy uri should be the first uri")
+	}
+
+	// Older clients only send the single uri
+	testData = "{\"type\": 1,\"name\": \"name\",\"login\": {\"uri\": \"legacy\"}}"
+	r = ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	Ci, err = unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if len(Ci.Data.Uris) != 1 || Ci.Data.Uris[0].Uri != "legacy" {
+		t.Fatal("Legacy uri should be the only entry in uris")
+	}
+
+	testData = "{\"type\": 1,\"name\": \"name\",\"login\": {\"uris\": [{\"uri\": \"a\", \"match\": 9}]}}"
+	r = ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	_, err = unmarshalCipher(r)
+	if err == nil {
+		t.Fatal("Expected error for invalid match type")
+	}
+}
+
+func TestUnmarshalCipherMeta(t *testing.T) {
+	testData := "{\"type\": 1,\"folderId\": \"0e5d5f3e-0c6b-4a33-9b06-8ea24b0ce3b4\",\"organizationId\": null,\"name\": \"name\",\"favorite\": true,\"reprompt\": 1,\"login\": {\"totp\": \"2.T57BwAuV8ubIn/sZPbQC+A==|EhUSSpJWSzSYOdJ/AQzfXuUXxwzcs/6C4tOXqhWAqcM=|OWV2VIqLfoWPs9DiouXGUOtTEkVeklbtJQHkQFIXkC8=\"}}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	Ci, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if Ci.FolderId == nil || *Ci.FolderId != "0e5d5f3e-0c6b-4a33-9b06-8ea24b0ce3b4" {
+		t.Fatal("Wrong folder id")
+	}
+
+	if Ci.OrganizationId != nil {
+		t.Fatal("Should be nil og android app will crash")
+	}
+
+	if !Ci.Favorite {
+		t.Fatal("Should be favorite")
+	}
+
+	if Ci.Data.Totp == nil {
+		t.Fatal("Totp should be set")
+	}
+
+	if Ci.Reprompt != CipherRepromptPassword {
+		t.Fatal("Should reprompt for the password")
+	}
+
+	r = ioutil.NopCloser(bytes.NewBuffer([]byte("{\"type\": 1,\"name\": \"name\",\"reprompt\": 2}")))
+	_, err = unmarshalCipher(r)
+	if err == nil {
+		t.Fatal("Expected error for invalid reprompt")
+	}
+}
+
+func TestSizeName(t *testing.T) {
+	cases := []struct {
+		size     int64
+		expected string
+	}{{0, "0 Bytes"}, {1023, "1023 Bytes"}, {1024, "1 KB"}, {1536, "1.5 KB"}, {5 << 20, "5 MB"}}
+
+	for _, c := range cases {
+		if sizeName(c.size) != c.expected {
+			t.Errorf("Expected %v got %v", c.expected, sizeName(c.size))
+		}
+	}
+}
+
+func TestUnmarshalCipherPasswordHistory(t *testing.T) {
+	testData := "{\"type\": 1,\"name\": \"name\",\"login\": {\"password\": \"new\",\"passwordRevisionDate\": \"2017-11-01T10:00:00Z\"},\"passwordHistory\": [" +
+		"{\"password\": \"1\",\"lastUsedDate\": \"2017-01-01T10:00:00Z\"},{\"password\": \"2\",\"lastUsedDate\": \"2017-02-01T10:00:00Z\"}," +
+		"{\"password\": \"3\",\"lastUsedDate\": \"2017-03-01T10:00:00Z\"},{\"password\": \"6\",\"lastUsedDate\": \"2017-06-01T10:00:00Z\"}," +
+		"{\"password\": \"5\",\"lastUsedDate\": \"2017-05-01T10:00:00Z\"},{\"password\": \"4\",\"lastUsedDate\": \"2017-04-01T10:00:00Z\"}]}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	Ci, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if Ci.Data.PasswordRevisionDate == nil {
+		t.Fatal("Password revision date should be set")
+	}
+
+	if len(Ci.PasswordHistory) != maxPasswordHistory {
+		t.Fatalf("Expected %d entries got %d", maxPasswordHistory, len(Ci.PasswordHistory))
+	}
+
+	// The oldest password is dropped
+	if Ci.PasswordHistory[0].Password != "6" || Ci.PasswordHistory[maxPasswordHistory-1].Password != "2" {
+		t.Fatal("Wrong passwords kept in history")
+	}
+}
+
+func TestUnmarshalCipherFido2Credentials(t *testing.T) {
+	testData := "{\"type\": 1,\"name\": \"name\",\"login\": {\"fido2Credentials\": [{\"credentialId\": \"id\",\"keyType\": \"public-key\",\"keyAlgorithm\": \"ECDSA\"," +
+		"\"keyCurve\": \"P-256\",\"keyValue\": \"key\",\"rpId\": \"example.com\",\"userHandle\": \"handle\",\"userName\": null,\"counter\": \"0\"," +
+		"\"discoverable\": \"true\",\"creationDate\": \"2023-10-01T10:00:00Z\"}]}}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	Ci, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if len(Ci.Data.Fido2Credentials) != 1 {
+		t.Fatalf("Expected 1 credential got %d", len(Ci.Data.Fido2Credentials))
+	}
+
+	f := Ci.Data.Fido2Credentials[0]
+	if f.RpId != "example.com" || f.KeyValue != "key" || f.CreationDate.Year() != 2023 {
+		t.Fatalf("Wrong credential %+v", f)
+	}
+
+	if f.UserHandle == nil || *f.UserHandle != "handle" || f.UserName != nil {
+		t.Fatal("Optional fields should be null when empty")
+	}
+
+	// Stored in the data blob
+	b, err := Ci.Data.bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data CipherData
+	err = json.Unmarshal(b, &data)
+	if err != nil || len(data.Fido2Credentials) != 1 || data.Fido2Credentials[0].CredentialId != "id" {
+		t.Fatal("Credentials should be kept in the data blob")
+	}
+}
+
+func TestUnmarshalCipherSshKey(t *testing.T) {
+	testData := "{\"type\": 5,\"name\": \"name\",\"sshKey\": {\"privateKey\": \"private\",\"publicKey\": \"public\",\"keyFingerprint\": \"fingerprint\"}}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	Ci, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if Ci.Type != CipherTypeSshKey || Ci.Data.SshKey == nil {
+		t.Fatal("SSH key should be set")
+	}
+
+	if Ci.Data.SshKey.PrivateKey != "private" || Ci.Data.SshKey.PublicKey != "public" || Ci.Data.SshKey.KeyFingerprint != "fingerprint" {
+		t.Fatalf("Wrong SSH key %+v", Ci.Data.SshKey)
+	}
+}
+
+func TestUnmarshalCipherSshKeyOtherType(t *testing.T) {
+	testData := "{\"type\": 1,\"name\": \"name\",\"sshKey\": {\"privateKey\": \"private\",\"publicKey\": \"public\",\"keyFingerprint\": \"fingerprint\"}}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	Ci, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if Ci.Data.SshKey != nil {
+		t.Fatal("SSH key should only be kept for SSH key ciphers")
+	}
+}
+
+func TestQuotaLimits(t *testing.T) {
+	items := int64(5)
+	unlimited := int64(0)
+	l := Quota{Items: &items, AttachmentBytes: &unlimited}.limits()
+
+	if l.Items != 5 || l.DataBytes != defaultQuotaDataBytes || l.AttachmentBytes != 0 {
+		t.Fatalf("Wrong limits %+v", l)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal WebSocket server (RFC 6455), enough for the notifications hub.
+// Extensions and subprotocols aren't supported.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes
+const (
+	wsContinuation = 0
+	wsText         = 1
+	wsBinary       = 2
+	wsClose        = 8
+	wsPing         = 9
+	wsPong         = 10
+)
+
+const maxWebSocketMessage = 64 << 10 // 64 KB
+const wsWriteTimeout = 10 * time.Second
+
+type wsConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// upgradeWebSocket answers the opening handshake and takes over the
+// connection. If it fails the error response has already been sent.
+func upgradeWebSocket(w http.ResponseWriter, req *http.Request) (*wsConn, error) {
+	key := req.Header.Get("Sec-Websocket-Key")
+	if req.Method != "GET" || !headerHasToken(req.Header, "Connection", "upgrade") ||
+		!headerHasToken(req.Header, "Upgrade", "websocket") || key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(400)))
+		return nil, errors.New("not a websocket handshake")
+	}
+
+	if req.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		w.Write([]byte(http.StatusText(426)))
+		return nil, errors.New("unsupported websocket version " + req.Header.Get("Sec-Websocket-Version"))
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(500)))
+		return nil, errors.New("connection can't be taken over")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// headerHasToken checks if a comma separated header contains the token
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the next text or binary message with its opcode.
+// Pings are answered on the way. Returns io.EOF when the client closes the
+// connection.
+func (c *wsConn) readMessage() (int, []byte, error) {
+	var msg []byte
+	opcode := wsContinuation
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case wsPing:
+			err = c.writeMessage(wsPong, payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeMessage(wsClose, nil)
+			return 0, nil, io.EOF
+		case wsContinuation:
+			if opcode == wsContinuation {
+				return 0, nil, errors.New("unexpected continuation frame")
+			}
+		case wsText, wsBinary:
+			if opcode != wsContinuation {
+				return 0, nil, errors.New("expected a continuation frame")
+			}
+			opcode = op
+		default:
+			return 0, nil, errors.New("unknown websocket opcode")
+		}
+
+		if len(msg)+len(payload) > maxWebSocketMessage {
+			return 0, nil, errors.New("websocket message too large")
+		}
+		msg = append(msg, payload...)
+
+		if fin {
+			return opcode, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (bool, int, []byte, error) {
+	var head [2]byte
+	_, err := io.ReadFull(c.r, head[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := head[0]&0x80 != 0
+	opcode := int(head[0] & 0x0f)
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("unmasked frame from client")
+	}
+
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var b [2]byte
+		_, err = io.ReadFull(c.r, b[:])
+		size = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		_, err = io.ReadFull(c.r, b[:])
+		size = binary.BigEndian.Uint64(b[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if size > maxWebSocketMessage {
+		return false, 0, nil, errors.New("websocket frame too large")
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(c.r, mask[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(c.r, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeMessage sends the data in a single frame. Safe to call from
+// multiple goroutines.
+func (c *wsConn) writeMessage(opcode int, data []byte) error {
+	frame := []byte{0x80 | byte(opcode)}
+	switch n := len(data); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(n))
+		frame = append(append(frame, 127), b[:]...)
+	}
+	frame = append(frame, data...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}